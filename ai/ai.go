@@ -22,6 +22,12 @@ type OrderRequest struct {
 	TurnOrdinal int
 	// The IDs of the AIs in the game, for those interested
 	AIs map[state.PlayerId]string
+	// Rules are the rules the game is played by.
+	Rules state.Rules
+	// Verdicts describe what happened to the orders the receiving AI gave last turn.
+	Verdicts state.Verdicts
+	// Events are the events of the last turn the receiving AI is allowed to see.
+	Events state.Events
 }
 
 /*
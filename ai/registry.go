@@ -0,0 +1,94 @@
+package ai
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"github.com/zond/stockholm-ai/common"
+	"github.com/zond/stockholm-ai/state"
+)
+
+/*
+Registry contains AIs by name, so that they can play in the same process as the hub without going through HTTP.
+*/
+type Registry struct {
+	lock sync.RWMutex
+	ais  map[string]AI
+}
+
+/*
+NewRegistry returns an empty registry.
+*/
+func NewRegistry() *Registry {
+	return &Registry{
+		ais: map[string]AI{},
+	}
+}
+
+/*
+DefaultRegistry is where the bundled AIs register themselves.
+*/
+var DefaultRegistry = NewRegistry()
+
+/*
+Register makes ai available as name in the default registry.
+*/
+func Register(name string, ai AI) {
+	DefaultRegistry.Register(name, ai)
+}
+
+/*
+Register makes ai available as name.
+*/
+func (self *Registry) Register(name string, ai AI) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.ais[name] = ai
+}
+
+/*
+Get returns the AI registered as name, if any.
+*/
+func (self *Registry) Get(name string) (result AI, found bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	result, found = self.ais[name]
+	return
+}
+
+/*
+Names returns the names of all registered AIs, sorted.
+*/
+func (self *Registry) Names() (result []string) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	for name, _ := range self.ais {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return
+}
+
+/*
+Orders asks the AI registered as name for orders, turning panics into errors the same way HTTPHandlerFunc turns them into 500s.
+
+The AI gets its own copy of the state in req, so it can't modify the state other AIs see.
+*/
+func (self *Registry) Orders(logger common.Logger, name string, req OrderRequest) (result state.Orders, err error) {
+	ai, found := self.Get(name)
+	if !found {
+		return nil, fmt.Errorf("No AI registered as %#v", name)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("Error delivering orders: %v\n%v", e, string(debug.Stack()))
+		}
+	}()
+	if req.State != nil {
+		req.State = req.State.Clone()
+	}
+	result = ai.Orders(logger, req)
+	return
+}
@@ -10,6 +10,7 @@ import (
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
+	ai.Register("broken", Broken{})
 }
 
 /*
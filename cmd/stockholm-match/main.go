@@ -0,0 +1,248 @@
+/*
+stockholm-match plays a single game between AIs on the local machine, without any hub, and writes the full game log to disk.
+
+Each argument is an AI, either the URL of an AI served with ai.HTTPHandlerFunc or the name of an AI in ai.DefaultRegistry (like the bundled simpleton, randomizer or broken).
+
+	stockholm-match -seed 42 -out game.json simpleton randomizer http://localhost:8081/
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zond/stockholm-ai/ai"
+	"github.com/zond/stockholm-ai/common"
+	"github.com/zond/stockholm-ai/state"
+
+	// register the example AIs
+	_ "github.com/zond/stockholm-ai/broken/ai"
+	_ "github.com/zond/stockholm-ai/randomizer/ai"
+	_ "github.com/zond/stockholm-ai/simpleton/ai"
+)
+
+/*
+player knows how to get orders from one AI.
+*/
+type player struct {
+	Id       state.PlayerId
+	Endpoint string
+	local    bool
+	client   *http.Client
+}
+
+func (self *player) orders(logger common.Logger, req ai.OrderRequest) (result state.Orders, err error) {
+	if self.local {
+		return ai.DefaultRegistry.Orders(logger, self.Endpoint, req)
+	}
+	sendBody := &bytes.Buffer{}
+	common.MustEncodeJSON(sendBody, req)
+	resp, err := self.client.Post(self.Endpoint, "application/json; charset=UTF-8", sendBody)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	recvBody := &bytes.Buffer{}
+	if _, err = io.Copy(recvBody, resp.Body); err != nil {
+		return
+	}
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("Got %v from %v: %v", resp.StatusCode, self.Endpoint, recvBody.String())
+		return
+	}
+	err = json.Unmarshal(recvBody.Bytes(), &result)
+	return
+}
+
+/*
+TurnError is an error from an AI during a turn.
+*/
+type TurnError struct {
+	Turn     int
+	PlayerId state.PlayerId
+	Error    string
+}
+
+/*
+GameLog is everything that happened in a game.
+*/
+type GameLog struct {
+	Seed       int64
+	Rules      state.Rules
+	Generator  string `json:",omitempty"`
+	Map        string `json:",omitempty"`
+	AIs        map[state.PlayerId]string
+	Turns      []*state.State
+	Winner     *state.PlayerId
+	Placements state.Placements
+	Errors     []TurnError
+}
+
+func main() {
+	seed := flag.Int64("seed", time.Now().UnixNano(), "Seed for the map generator")
+	generatorName := flag.String("generator", "random", fmt.Sprintf("Map generator, one of %v", state.MapGeneratorNames()))
+	mapFile := flag.String("map", "", "Map file to play on instead of a generated map")
+	rulesFile := flag.String("rules", "", "JSON file with the rules to play by, instead of the default rules")
+	out := flag.String("out", "game.json", "Where to write the game log")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout for each request to an AI")
+	quiet := flag.Bool("quiet", false, "Don't log each turn")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v [options] AI AI...\n\nEach AI is either an URL or one of the registered AIs: %v\n\n", os.Args[0], strings.Join(ai.DefaultRegistry.Names(), ", "))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "", 0)
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	rules := state.DefaultRules()
+	if *rulesFile != "" {
+		b, err := ioutil.ReadFile(*rulesFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		rules = state.Rules{}
+		if err = json.Unmarshal(b, &rules); err != nil {
+			logger.Fatal(err)
+		}
+		rules = rules.OrDefault()
+	}
+	if err := rules.Validate(); err != nil {
+		logger.Fatal(err)
+	}
+
+	players := []*player{}
+	playerIds := []state.PlayerId{}
+	gameLog := &GameLog{
+		Seed:  *seed,
+		Rules: rules,
+		AIs:   map[state.PlayerId]string{},
+	}
+	for index, endpoint := range flag.Args() {
+		p := &player{
+			Id:       state.PlayerId(fmt.Sprintf("%v-%v", index, endpoint)),
+			Endpoint: endpoint,
+			client: &http.Client{
+				Timeout: *timeout,
+			},
+		}
+		if _, found := ai.DefaultRegistry.Get(endpoint); found {
+			p.local = true
+		} else if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+			logger.Fatalf("%#v is neither an URL nor a bundled AI", endpoint)
+		}
+		players = append(players, p)
+		playerIds = append(playerIds, p.Id)
+		gameLog.AIs[p.Id] = endpoint
+	}
+
+	var s *state.State
+	if *mapFile == "" {
+		generator, err := state.GetMapGenerator(*generatorName)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		gameLog.Generator = *generatorName
+		if s, err = state.GenerateState(logger, generator, *seed, rules, playerIds); err != nil {
+			logger.Fatal(err)
+		}
+	} else {
+		in, err := os.Open(*mapFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		m, err := state.LoadMap(in)
+		in.Close()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		gameLog.Map = m.Name
+		if s, err = m.State(rules, playerIds); err != nil {
+			logger.Fatal(err)
+		}
+	}
+	gameLog.Turns = append(gameLog.Turns, s.Clone())
+
+	type response struct {
+		playerId state.PlayerId
+		orders   state.Orders
+		err      error
+	}
+	gameId := state.GameId(fmt.Sprintf("local-%v", *seed))
+	for turn := 0; turn < rules.MaxTurns && gameLog.Winner == nil; turn++ {
+		responses := make(chan response, len(players))
+		for _, p := range players {
+			p := p
+			if s.Eliminated[p.Id] > 0 {
+				responses <- response{
+					playerId: p.Id,
+				}
+				continue
+			}
+			go func() {
+				resp := response{
+					playerId: p.Id,
+				}
+				visible := s.VisibleTo(p.Id, rules)
+				resp.orders, resp.err = p.orders(logger, ai.OrderRequest{
+					Me:          p.Id,
+					GameId:      gameId,
+					State:       visible,
+					TurnOrdinal: turn,
+					AIs:         gameLog.AIs,
+					Rules:       rules,
+					Verdicts:    s.Verdicts[p.Id],
+					Events:      visible.Events,
+				})
+				responses <- resp
+			}()
+		}
+		orderMap := map[state.PlayerId]state.Orders{}
+		for _, _ = range players {
+			resp := <-responses
+			orderMap[resp.playerId] = resp.orders
+			if resp.err != nil {
+				logger.Printf("Turn %v: %v failed: %v", turn, resp.playerId, strings.SplitN(resp.err.Error(), "\n", 2)[0])
+				gameLog.Errors = append(gameLog.Errors, TurnError{
+					Turn:     turn,
+					PlayerId: resp.playerId,
+					Error:    resp.err.Error(),
+				})
+			}
+		}
+		gameLog.Winner = s.Next(logger, orderMap)
+		gameLog.Turns = append(gameLog.Turns, s.Clone())
+		if !*quiet {
+			logger.Printf("Turn %v: %v", turn, s.Units())
+		}
+	}
+	gameLog.Placements = s.Ranking(playerIds, s.Eliminated)
+	if gameLog.Winner == nil {
+		logger.Printf("No winner after %v turns", len(gameLog.Turns)-1)
+	} else {
+		logger.Printf("%v won after %v turns", *gameLog.Winner, len(gameLog.Turns)-1)
+	}
+	for _, placement := range gameLog.Placements {
+		logger.Printf("%v. %v with %v units on %v nodes", placement.Rank, placement.Player, placement.Units, placement.Nodes)
+	}
+
+	b, err := json.MarshalIndent(gameLog, "", "  ")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err = ioutil.WriteFile(*out, b, 0644); err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -36,6 +36,17 @@ func RandomString(n int) string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
+/*
+RandomStringFrom is like RandomString, but draws from r instead of the global source.
+*/
+func RandomStringFrom(r *rand.Rand, n int) string {
+	b := make([]byte, 0, n)
+	for i := 0; i < n; i++ {
+		b = append(b, byte(r.Int()))
+	}
+	return base64.URLEncoding.EncodeToString(b)
+}
+
 type Logger interface {
 	Printf(f string, o ...interface{})
 }
@@ -117,7 +128,18 @@ func Min(is ...int) (result int) {
 Norm returns a random int in the normal distribution with average avg and standard deviance dev, strictly limited by min and max (inclusive).
 */
 func Norm(avg, dev, min, max int) (result int) {
-	result = int(rand.NormFloat64()*float64(dev) + float64(avg))
+	return norm(rand.NormFloat64(), avg, dev, min, max)
+}
+
+/*
+NormFrom is like Norm, but draws from r instead of the global source.
+*/
+func NormFrom(r *rand.Rand, avg, dev, min, max int) (result int) {
+	return norm(r.NormFloat64(), avg, dev, min, max)
+}
+
+func norm(f float64, avg, dev, min, max int) (result int) {
+	result = int(f*float64(dev) + float64(avg))
 	if result < min {
 		result = min
 	}
@@ -5,6 +5,7 @@ go 1.15
 require (
 	github.com/golang/snappy v0.0.2
 	github.com/gorilla/mux v1.8.0
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/net v0.0.0-20210119194325-5f4716e94777
 	google.golang.org/appengine v1.6.7
 )
@@ -11,19 +11,10 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/user"
 )
 
 var prefPattern = regexp.MustCompile("^([^\\s;]+)(;q=([\\d.]+))?$")
 
-func Transaction(outerContext Context, f func(Context) error) error {
-	return datastore.RunInTransaction(outerContext, func(innerContext context.Context) error {
-		cpy := outerContext
-		cpy.Context = innerContext
-		return f(cpy)
-	}, &datastore.TransactionOptions{XG: true})
-}
-
 type GAELogger struct {
 	context.Context
 }
@@ -55,7 +46,7 @@ type Context struct {
 	Req     *http.Request
 	Resp    http.ResponseWriter
 	Version string
-	User    *user.User
+	User    *User
 	Vars    map[string]string
 }
 
@@ -63,20 +54,20 @@ func (self Context) Authenticated() bool {
 	if self.User != nil {
 		return true
 	}
-	fmt.Fprintln(self.Resp, "Unauthorized")
 	self.Resp.WriteHeader(401)
+	fmt.Fprintln(self.Resp, "Unauthorized")
 	return false
 }
 
 func (self Context) DevServer() bool {
-	return appengine.IsDevAppServer()
+	return platform.IsDevServer()
 }
 
 func SetContentType(w http.ResponseWriter, t string, cache bool) {
 	w.Header().Set("Content-Type", t)
 	w.Header().Set("Vary", "Accept")
 	if cache {
-		if !appengine.IsDevAppServer() {
+		if !platform.IsDevServer() {
 			w.Header().Set("Cache-Control", "public, max-age=864000")
 		}
 	} else {
@@ -0,0 +1,145 @@
+package common
+
+import (
+	"net/http"
+
+	"github.com/zond/stockholm-ai/common"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+	"google.golang.org/appengine/user"
+)
+
+/*
+User is a logged in user.
+*/
+type User struct {
+	Email string
+	Admin bool
+}
+
+/*
+Platform is the environment the hub runs in, and provides everything the hub needs from it except storage.
+*/
+type Platform interface {
+	// NewContext returns the context to use when serving r.
+	NewContext(r *http.Request) context.Context
+	// CurrentUser returns the logged in user making r, or nil.
+	CurrentUser(c context.Context, r *http.Request) *User
+	// Login makes the user log in, and then go back to the root of the site.
+	Login(c context.Context, w http.ResponseWriter, r *http.Request) error
+	// Logout makes the user log out, and then go back to the root of the site.
+	Logout(c context.Context, w http.ResponseWriter, r *http.Request) error
+	// HTTPClient returns a client to use when talking to AIs.
+	HTTPClient(c context.Context) *http.Client
+	// Infof logs at info level.
+	Infof(c context.Context, f string, i ...interface{})
+	// Errorf logs at error level.
+	Errorf(c context.Context, f string, i ...interface{})
+	// VersionID returns the version of the running code, used to bust caches of static content.
+	VersionID(c context.Context) string
+	// IsDevServer returns whether this is a development server.
+	IsDevServer() bool
+}
+
+var platform Platform = GAEPlatform{}
+
+/*
+SetPlatform makes the hub run on p instead of on Google App Engine.
+*/
+func SetPlatform(p Platform) {
+	platform = p
+}
+
+/*
+CurrentPlatform returns the platform the hub runs on.
+*/
+func CurrentPlatform() Platform {
+	return platform
+}
+
+func Infof(c context.Context, f string, i ...interface{}) {
+	platform.Infof(c, f, i...)
+}
+
+func Errorf(c context.Context, f string, i ...interface{}) {
+	platform.Errorf(c, f, i...)
+}
+
+func HTTPClient(c context.Context) *http.Client {
+	return platform.HTTPClient(c)
+}
+
+/*
+PlatformLogger logs at info level on the current platform.
+*/
+type PlatformLogger struct {
+	context.Context
+}
+
+func (self PlatformLogger) Printf(f string, i ...interface{}) {
+	platform.Infof(self.Context, f, i...)
+}
+
+func PlatformLoggerFactory(r *http.Request) common.Logger {
+	return PlatformLogger{Context: platform.NewContext(r)}
+}
+
+/*
+GAEPlatform runs the hub on Google App Engine.
+*/
+type GAEPlatform struct{}
+
+func (self GAEPlatform) NewContext(r *http.Request) context.Context {
+	return appengine.NewContext(r)
+}
+
+func (self GAEPlatform) CurrentUser(c context.Context, r *http.Request) *User {
+	if u := user.Current(c); u != nil {
+		return &User{
+			Email: u.Email,
+			Admin: u.Admin,
+		}
+	}
+	return nil
+}
+
+func (self GAEPlatform) redirect(w http.ResponseWriter, url string, err error) error {
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", url)
+	w.WriteHeader(302)
+	return nil
+}
+
+func (self GAEPlatform) Login(c context.Context, w http.ResponseWriter, r *http.Request) error {
+	url, err := user.LoginURL(c, r.URL.Scheme+r.URL.Host)
+	return self.redirect(w, url, err)
+}
+
+func (self GAEPlatform) Logout(c context.Context, w http.ResponseWriter, r *http.Request) error {
+	url, err := user.LogoutURL(c, r.URL.Scheme+r.URL.Host)
+	return self.redirect(w, url, err)
+}
+
+func (self GAEPlatform) HTTPClient(c context.Context) *http.Client {
+	return urlfetch.Client(c)
+}
+
+func (self GAEPlatform) Infof(c context.Context, f string, i ...interface{}) {
+	log.Infof(c, f, i...)
+}
+
+func (self GAEPlatform) Errorf(c context.Context, f string, i ...interface{}) {
+	log.Errorf(c, f, i...)
+}
+
+func (self GAEPlatform) VersionID(c context.Context) string {
+	return appengine.VersionID(c)
+}
+
+func (self GAEPlatform) IsDevServer() bool {
+	return appengine.IsDevAppServer()
+}
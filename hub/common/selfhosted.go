@@ -0,0 +1,140 @@
+package common
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+/*
+SelfHostedPlatform runs the hub as an ordinary net/http server.
+
+Users log in with HTTP basic authentication, checked against Users.
+*/
+type SelfHostedPlatform struct {
+	// Users contains the hex encoded SHA-256 sum of the password of each user email.
+	Users map[string]string
+	// Admins contains the emails of the users that are admins.
+	Admins map[string]bool
+	// Version is used to bust caches of static content.
+	Version string
+	// Dev disables caching of static content.
+	Dev bool
+	// Logger is where everything is logged.
+	Logger *log.Logger
+	// Client is used to talk to AIs.
+	Client *http.Client
+}
+
+/*
+NewSelfHostedPlatform returns a platform with users loaded from usersFile, which should contain lines like "email:sha256 of password in hex", optionally followed by ":admin".
+An empty usersFile means nobody can log in.
+*/
+func NewSelfHostedPlatform(usersFile string) (result *SelfHostedPlatform, err error) {
+	result = &SelfHostedPlatform{
+		Users:   map[string]string{},
+		Admins:  map[string]bool{},
+		Version: fmt.Sprint(time.Now().Unix()),
+		Logger:  log.New(os.Stderr, "", log.LstdFlags),
+		Client: &http.Client{
+			Timeout: time.Minute,
+		},
+	}
+	if usersFile == "" {
+		return
+	}
+	in, err := os.Open(usersFile)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "admin") {
+			return nil, fmt.Errorf("Lines in %v should look like email:sha256sum[:admin], not %#v", usersFile, line)
+		}
+		result.Users[parts[0]] = strings.ToLower(parts[1])
+		result.Admins[parts[0]] = len(parts) == 3
+	}
+	err = scanner.Err()
+	return
+}
+
+func (self *SelfHostedPlatform) NewContext(r *http.Request) context.Context {
+	return r.Context()
+}
+
+func (self *SelfHostedPlatform) CurrentUser(c context.Context, r *http.Request) *User {
+	email, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	want, found := self.Users[email]
+	if !found {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(want)) != 1 {
+		return nil
+	}
+	return &User{
+		Email: email,
+		Admin: self.Admins[email],
+	}
+}
+
+func (self *SelfHostedPlatform) Login(c context.Context, w http.ResponseWriter, r *http.Request) error {
+	if self.CurrentUser(c, r) == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="stockholm-ai"`)
+		w.WriteHeader(401)
+		fmt.Fprintln(w, "Unauthorized")
+		return nil
+	}
+	w.Header().Set("Location", "/")
+	w.WriteHeader(302)
+	return nil
+}
+
+/*
+Logout can't make browsers forget basic authentication credentials, but answering 401 makes most of them do it.
+*/
+func (self *SelfHostedPlatform) Logout(c context.Context, w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(401)
+	fmt.Fprintln(w, `Logged out, go back to <a href="/">stockholm-ai</a>.`)
+	return nil
+}
+
+func (self *SelfHostedPlatform) HTTPClient(c context.Context) *http.Client {
+	return self.Client
+}
+
+func (self *SelfHostedPlatform) Infof(c context.Context, f string, i ...interface{}) {
+	self.Logger.Printf("INFO: "+f, i...)
+}
+
+func (self *SelfHostedPlatform) Errorf(c context.Context, f string, i ...interface{}) {
+	self.Logger.Printf("ERROR: "+f, i...)
+}
+
+func (self *SelfHostedPlatform) VersionID(c context.Context) string {
+	return self.Version
+}
+
+func (self *SelfHostedPlatform) IsDevServer() bool {
+	return self.Dev
+}
@@ -6,33 +6,30 @@ import (
 	"time"
 
 	"github.com/zond/stockholm-ai/hub/common"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
+	"github.com/zond/stockholm-ai/rating"
 )
 
+/*
+AIErrorType tells timeouts from other errors.
+*/
+type AIErrorType string
+
 const (
-	AIKind      = "AI"
-	AIErrorKind = "AIError"
-	AllAIsKey   = "AIs{All}"
+	AIErrorTimeout AIErrorType = "Timeout"
+	AIErrorFailure AIErrorType = "Failure"
 )
 
-func aIByIdKey(k interface{}) string {
-	return fmt.Sprintf("AI{Id:%v}", k)
-}
-
-func aiErrorsKeyByParent(k interface{}) string {
-	return fmt.Sprintf("AIErrors{Parent:%v}", k)
-}
-
 type AIError struct {
-	Turn              *datastore.Key
-	Game              *datastore.Key `datastore:"-"`
-	Error             string         `datastore:"-"`
-	ErrorDetail1      string         `datastore:"-"`
-	ErrorDetail2      string         `datastore:"-"`
-	ErrorBytes        []byte         `json:"-"`
-	ErrorDetail1Bytes []byte         `json:"-"`
-	ErrorDetail2Bytes []byte         `json:"-"`
+	GameId            string `datastore:"-"`
+	TurnOrdinal       int
+	Type              AIErrorType
+	Latency           time.Duration
+	Error             string `datastore:"-"`
+	ErrorDetail1      string `datastore:"-"`
+	ErrorDetail2      string `datastore:"-"`
+	ErrorBytes        []byte `json:"-"`
+	ErrorDetail1Bytes []byte `json:"-"`
+	ErrorDetail2Bytes []byte `json:"-"`
 	CreatedAt         time.Time
 }
 
@@ -40,7 +37,6 @@ func (self *AIError) process(c common.Context) *AIError {
 	self.Error = string(self.ErrorBytes)
 	self.ErrorDetail1 = string(self.ErrorDetail1Bytes)
 	self.ErrorDetail2 = string(self.ErrorDetail2Bytes)
-	self.Game = self.Turn.Parent()
 	return self
 }
 
@@ -87,49 +83,66 @@ func (self AIs) process(c common.Context) AIs {
 }
 
 type AI struct {
-	Id        *datastore.Key
-	URL       string
-	Name      string
-	Games     int
-	Wins      int
-	Losses    int
-	Owner     string `json:"-"`
-	IsOwner   bool   `datastore:"-"`
-	CreatedAt time.Time
-}
-
-func (self *AI) AddError(c common.Context, turnId *datastore.Key, err error) {
-	_, e := datastore.Put(c, datastore.NewKey(c, AIErrorKind, "", 0, self.Id), &AIError{
+	Id     string `datastore:"-"`
+	URL    string
+	Name   string
+	Games  int
+	Wins   int
+	Losses int
+	Draws  int
+	// Placements contain the number of games this AI was ranked first, second, and so on, in.
+	Placements []int
+	// Rating is the estimated skill of this AI, updated after each finished game.
+	Rating rating.Rating
+	// ConservativeRating is a skill this AI very likely has at least, used to rank AIs.
+	ConservativeRating float64 `datastore:"-"`
+	// Ladder is whether the matchmaker creates games for this AI.
+	Ladder bool
+	// Suspended is whether the matchmaker stopped creating games for this AI due to its error rate. Joining the ladder again lifts it.
+	Suspended bool
+	// ErrorRate is a moving average of the share of turns this AI failed to give orders in, over its latest finished games.
+	ErrorRate float64
+	// LastLadderGame is when the matchmaker last created a game for this AI.
+	LastLadderGame time.Time
+	// MoveDeadlineMillis, if not 0, shortens the move deadline of games with a longer one.
+	MoveDeadlineMillis int
+	Owner              string `json:"-"`
+	IsOwner            bool   `datastore:"-"`
+	CreatedAt          time.Time
+}
+
+/*
+AddError remembers that self failed to give orders for the turn after turnOrdinal, and how long it took to fail.
+*/
+func (self *AI) AddError(c common.Context, gameId string, turnOrdinal int, latency time.Duration, err error) {
+	errorType := AIErrorFailure
+	if _, ok := err.(TimeoutError); ok {
+		errorType = AIErrorTimeout
+	}
+	if e := store.AIErrors().Add(c, self.Id, &AIError{
 		CreatedAt:         time.Now(),
-		Turn:              turnId,
+		GameId:            gameId,
+		TurnOrdinal:       turnOrdinal,
+		Type:              errorType,
+		Latency:           latency,
 		ErrorBytes:        []byte(err.Error()),
 		ErrorDetail1Bytes: []byte(fmt.Sprintf("%+v", err)),
 		ErrorDetail2Bytes: []byte(fmt.Sprintf("%#v", err)),
-	})
-	if e != nil {
-		log.Errorf(c, "Got %+v when trying to save a new error!", e)
+	}); e != nil {
+		common.Errorf(c, "Got %+v when trying to save a new error!", e)
 	}
-	common.MemDel(c, aiErrorsKeyByParent(self.Id))
-}
-
-func (self *AI) findErrors(c common.Context) (result AIErrors) {
-	_, err := datastore.NewQuery(AIErrorKind).Ancestor(self.Id).Order("-CreatedAt").Limit(50).GetAll(c, &result)
-	common.AssertOkError(err)
-	if result == nil {
-		result = AIErrors{}
-	}
-	return
 }
 
 func (self *AI) GetErrors(c common.Context) (result AIErrors) {
-	common.Memoize(c, aiErrorsKeyByParent(self.Id), &result, func() interface{} {
-		return self.findErrors(c)
-	})
+	result, err := store.AIErrors().Latest(c, self.Id, 50)
+	common.AssertOkError(err)
 	sort.Sort(result)
 	return result.process(c)
 }
 
 func (self *AI) process(c common.Context) *AI {
+	self.Rating = self.Rating.OrDefault()
+	self.ConservativeRating = self.Rating.Conservative()
 	if c.User != nil {
 		self.IsOwner = self.Owner == c.User.Email
 	}
@@ -139,61 +152,27 @@ func (self *AI) process(c common.Context) *AI {
 	return self
 }
 
-func findAIById(c common.Context, id *datastore.Key) *AI {
-	var ai AI
-	err := datastore.Get(c, id, &ai)
-	if err == datastore.ErrNoSuchEntity {
-		return nil
-	}
+func GetAIById(c common.Context, id string) *AI {
+	ai, err := store.AIs().Get(c, id)
 	common.AssertOkError(err)
-	ai.Id = id
-	return &ai
-}
-
-func GetAIById(c common.Context, id *datastore.Key) *AI {
-	var ai AI
-	if common.Memoize(c, aIByIdKey(id), &ai, func() interface{} {
-		return findAIById(c, id)
-	}) {
-		return &ai
-	}
-	return nil
-}
-
-func findAllAIs(c common.Context) (result AIs) {
-	ids, err := datastore.NewQuery(AIKind).GetAll(c, &result)
-	common.AssertOkError(err)
-	for index, id := range ids {
-		result[index].Id = id
-	}
-	if result == nil {
-		result = AIs{}
-	}
-	return
+	return ai
 }
 
 func GetAllAIs(c common.Context) (result AIs) {
-	common.Memoize(c, AllAIsKey, &result, func() interface{} {
-		return findAllAIs(c)
-	})
+	result, err := store.AIs().All(c)
+	common.AssertOkError(err)
 	sort.Sort(result)
 	return result.process(c)
 }
 
 func (self *AI) Delete(c common.Context) {
-	datastore.Delete(c, self.Id)
-	common.MemDel(c, AllAIsKey, aIByIdKey(self.Id))
+	common.AssertOkError(store.AIs().Delete(c, self.Id))
 }
 
 func (self *AI) Save(c common.Context) *AI {
-	var err error
-	if self.Id == nil {
+	if self.Id == "" {
 		self.CreatedAt = time.Now()
-		self.Id, err = datastore.Put(c, datastore.NewKey(c, AIKind, "", 0, nil), self)
-	} else {
-		_, err = datastore.Put(c, self.Id, self)
 	}
-	common.AssertOkError(err)
-	common.MemDel(c, AllAIsKey, aIByIdKey(self.Id))
+	common.AssertOkError(store.AIs().Save(c, self))
 	return self
 }
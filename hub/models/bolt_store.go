@@ -0,0 +1,507 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"go.etcd.io/bbolt"
+	"golang.org/x/net/context"
+)
+
+var (
+	aiBucket         = []byte(AIKind)
+	aiErrorBucket    = []byte(AIErrorKind)
+	ratingBucket     = []byte(RatingKind)
+	gameBucket       = []byte(GameKind)
+	turnBucket       = []byte(TurnKind)
+	mapBucket        = []byte(MapKind)
+	tournamentBucket = []byte(TournamentKind)
+)
+
+type boltTxKey struct{}
+
+/*
+BoltStore keeps everything in a BoltDB file, for hubs running outside Google App Engine.
+
+Ids are zero padded sequence numbers, so that they sort in the order they were created.
+*/
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+/*
+NewBoltStore opens, or creates, the BoltDB file at path.
+*/
+func NewBoltStore(path string) (result *BoltStore, err error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return
+	}
+	if err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{aiBucket, aiErrorBucket, ratingBucket, gameBucket, turnBucket, mapBucket, tournamentBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return
+	}
+	result = &BoltStore{
+		db: db,
+	}
+	return
+}
+
+func (self *BoltStore) Close() error {
+	return self.db.Close()
+}
+
+/*
+Transaction runs f in a BoltDB write transaction. Transactions inside it become part of it.
+*/
+func (self *BoltStore) Transaction(c context.Context, f func(context.Context) error) error {
+	if _, found := c.Value(boltTxKey{}).(*bbolt.Tx); found {
+		return f(c)
+	}
+	return self.db.Update(func(tx *bbolt.Tx) error {
+		return f(context.WithValue(c, boltTxKey{}, tx))
+	})
+}
+
+func (self *BoltStore) view(c context.Context, f func(*bbolt.Tx) error) error {
+	if tx, found := c.Value(boltTxKey{}).(*bbolt.Tx); found {
+		return f(tx)
+	}
+	return self.db.View(f)
+}
+
+func (self *BoltStore) update(c context.Context, f func(*bbolt.Tx) error) error {
+	if tx, found := c.Value(boltTxKey{}).(*bbolt.Tx); found {
+		return f(tx)
+	}
+	return self.db.Update(f)
+}
+
+func (self *BoltStore) AIs() AIRepository {
+	return boltAIs{self}
+}
+
+func (self *BoltStore) AIErrors() AIErrorRepository {
+	return boltAIErrors{self}
+}
+
+func (self *BoltStore) Ratings() RatingRepository {
+	return boltRatings{self}
+}
+
+func (self *BoltStore) Games() GameRepository {
+	return boltGames{self}
+}
+
+func (self *BoltStore) Turns() TurnRepository {
+	return boltTurns{self}
+}
+
+func (self *BoltStore) Maps() MapRepository {
+	return boltMaps{self}
+}
+
+func (self *BoltStore) Tournaments() TournamentRepository {
+	return boltTournaments{self}
+}
+
+func boltId(bucket *bbolt.Bucket) (string, error) {
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%012d", seq), nil
+}
+
+func boltOrdinal(ordinal int) []byte {
+	return []byte(fmt.Sprintf("%08d", ordinal))
+}
+
+func boltGet(bucket *bbolt.Bucket, key []byte, dst interface{}) (found bool, err error) {
+	if bucket == nil {
+		return
+	}
+	b := bucket.Get(key)
+	if b == nil {
+		return
+	}
+	if err = common.MemCodec.Unmarshal(b, dst); err != nil {
+		return
+	}
+	found = true
+	return
+}
+
+func boltPut(bucket *bbolt.Bucket, key []byte, src interface{}) error {
+	b, err := common.MemCodec.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, b)
+}
+
+type boltAIs struct {
+	*BoltStore
+}
+
+func (self boltAIs) Get(c context.Context, id string) (result *AI, err error) {
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		var ai AI
+		found, err := boltGet(tx.Bucket(aiBucket), []byte(id), &ai)
+		if found {
+			result = &ai
+		}
+		return err
+	})
+	return
+}
+
+func (self boltAIs) All(c context.Context) (result AIs, err error) {
+	result = AIs{}
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		return tx.Bucket(aiBucket).ForEach(func(k, v []byte) error {
+			var ai AI
+			if err := common.MemCodec.Unmarshal(v, &ai); err != nil {
+				return err
+			}
+			result = append(result, ai)
+			return nil
+		})
+	})
+	return
+}
+
+func (self boltAIs) Save(c context.Context, ai *AI) error {
+	return self.update(c, func(tx *bbolt.Tx) (err error) {
+		bucket := tx.Bucket(aiBucket)
+		if ai.Id == "" {
+			if ai.Id, err = boltId(bucket); err != nil {
+				return
+			}
+		}
+		return boltPut(bucket, []byte(ai.Id), ai)
+	})
+}
+
+func (self boltAIs) Delete(c context.Context, id string) error {
+	return self.update(c, func(tx *bbolt.Tx) error {
+		return tx.Bucket(aiBucket).Delete([]byte(id))
+	})
+}
+
+/*
+boltAIErrors keeps the errors of each AI in a bucket of their own, keyed by sequence number.
+*/
+type boltAIErrors struct {
+	*BoltStore
+}
+
+func (self boltAIErrors) Add(c context.Context, aiId string, aiError *AIError) error {
+	return self.update(c, func(tx *bbolt.Tx) error {
+		bucket, err := tx.Bucket(aiErrorBucket).CreateBucketIfNotExists([]byte(aiId))
+		if err != nil {
+			return err
+		}
+		id, err := boltId(bucket)
+		if err != nil {
+			return err
+		}
+		return boltPut(bucket, []byte(id), aiError)
+	})
+}
+
+func (self boltAIErrors) Latest(c context.Context, aiId string, limit int) (result AIErrors, err error) {
+	result = AIErrors{}
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(aiErrorBucket).Bucket([]byte(aiId))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil && len(result) < limit; k, v = cursor.Prev() {
+			var aiError AIError
+			if err := common.MemCodec.Unmarshal(v, &aiError); err != nil {
+				return err
+			}
+			result = append(result, aiError)
+		}
+		return nil
+	})
+	return
+}
+
+/*
+boltRatings keeps the rating changes of each AI in a bucket of their own, keyed by sequence number.
+*/
+type boltRatings struct {
+	*BoltStore
+}
+
+func (self boltRatings) Add(c context.Context, aiId string, change *RatingChange) error {
+	return self.update(c, func(tx *bbolt.Tx) error {
+		bucket, err := tx.Bucket(ratingBucket).CreateBucketIfNotExists([]byte(aiId))
+		if err != nil {
+			return err
+		}
+		id, err := boltId(bucket)
+		if err != nil {
+			return err
+		}
+		return boltPut(bucket, []byte(id), change)
+	})
+}
+
+func (self boltRatings) History(c context.Context, aiId string, limit int) (result RatingChanges, err error) {
+	result = RatingChanges{}
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(ratingBucket).Bucket([]byte(aiId))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil && len(result) < limit; k, v = cursor.Prev() {
+			var change RatingChange
+			if err := common.MemCodec.Unmarshal(v, &change); err != nil {
+				return err
+			}
+			result = append(result, change)
+		}
+		return nil
+	})
+	return
+}
+
+type boltGames struct {
+	*BoltStore
+}
+
+func (self boltGames) Get(c context.Context, id string) (result *Game, err error) {
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		var game Game
+		found, err := boltGet(tx.Bucket(gameBucket), []byte(id), &game)
+		if found {
+			result = &game
+		}
+		return err
+	})
+	return
+}
+
+func (self boltGames) Page(c context.Context, offset, limit int) (result GamePage, err error) {
+	result.Content = Games{}
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(gameBucket)
+		result.Total = bucket.Stats().KeyN
+		cursor := bucket.Cursor()
+		skipped := 0
+		for k, v := cursor.Last(); k != nil && len(result.Content) < limit; k, v = cursor.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			var game Game
+			if err := common.MemCodec.Unmarshal(v, &game); err != nil {
+				return err
+			}
+			result.Content = append(result.Content, game)
+		}
+		return nil
+	})
+	return
+}
+
+func (self boltGames) Save(c context.Context, game *Game) error {
+	return self.update(c, func(tx *bbolt.Tx) (err error) {
+		bucket := tx.Bucket(gameBucket)
+		if game.Id == "" {
+			if game.Id, err = boltId(bucket); err != nil {
+				return
+			}
+		}
+		return boltPut(bucket, []byte(game.Id), game)
+	})
+}
+
+func (self boltGames) Unfinished(c context.Context) (result []string, err error) {
+	result = []string{}
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		return tx.Bucket(gameBucket).ForEach(func(k, v []byte) error {
+			var game Game
+			if err := common.MemCodec.Unmarshal(v, &game); err != nil {
+				return err
+			}
+			if game.State == StateCreated || game.State == StatePlaying || game.State == StatePaused {
+				result = append(result, game.Id)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+/*
+boltTurns keeps the turns of each game in a bucket of their own, keyed by zero padded ordinal.
+*/
+type boltTurns struct {
+	*BoltStore
+}
+
+func (self boltTurns) All(c context.Context, gameId string) (result Turns, err error) {
+	result = Turns{}
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(turnBucket).Bucket([]byte(gameId))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var turn Turn
+			if err := common.MemCodec.Unmarshal(v, &turn); err != nil {
+				return err
+			}
+			result = append(result, turn)
+			return nil
+		})
+	})
+	return
+}
+
+func (self boltTurns) Get(c context.Context, gameId string, ordinal int) (result *Turn, err error) {
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		var turn Turn
+		found, err := boltGet(tx.Bucket(turnBucket).Bucket([]byte(gameId)), boltOrdinal(ordinal), &turn)
+		if found {
+			result = &turn
+		}
+		return err
+	})
+	return
+}
+
+func (self boltTurns) Latest(c context.Context, gameId string) (result *Turn, err error) {
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(turnBucket).Bucket([]byte(gameId))
+		if bucket == nil {
+			return nil
+		}
+		k, _ := bucket.Cursor().Last()
+		if k == nil {
+			return nil
+		}
+		var turn Turn
+		if _, err := boltGet(bucket, k, &turn); err != nil {
+			return err
+		}
+		result = &turn
+		return nil
+	})
+	return
+}
+
+func (self boltTurns) Save(c context.Context, gameId string, turn *Turn) error {
+	return self.update(c, func(tx *bbolt.Tx) error {
+		bucket, err := tx.Bucket(turnBucket).CreateBucketIfNotExists([]byte(gameId))
+		if err != nil {
+			return err
+		}
+		// the state is already serialized in the turn
+		cpy := *turn
+		cpy.State = nil
+		return boltPut(bucket, boltOrdinal(turn.Ordinal), &cpy)
+	})
+}
+
+type boltMaps struct {
+	*BoltStore
+}
+
+func (self boltMaps) Get(c context.Context, name string) (result *Map, err error) {
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		var m Map
+		found, err := boltGet(tx.Bucket(mapBucket), []byte(name), &m)
+		if found {
+			result = &m
+		}
+		return err
+	})
+	return
+}
+
+func (self boltMaps) All(c context.Context) (result Maps, err error) {
+	result = Maps{}
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		return tx.Bucket(mapBucket).ForEach(func(k, v []byte) error {
+			var m Map
+			if err := common.MemCodec.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			result = append(result, m)
+			return nil
+		})
+	})
+	return
+}
+
+func (self boltMaps) Save(c context.Context, m *Map) error {
+	return self.update(c, func(tx *bbolt.Tx) error {
+		// the map is already serialized in m
+		cpy := *m
+		cpy.Map = nil
+		return boltPut(tx.Bucket(mapBucket), []byte(m.Name), &cpy)
+	})
+}
+
+func (self boltMaps) Delete(c context.Context, name string) error {
+	return self.update(c, func(tx *bbolt.Tx) error {
+		return tx.Bucket(mapBucket).Delete([]byte(name))
+	})
+}
+
+type boltTournaments struct {
+	*BoltStore
+}
+
+func (self boltTournaments) Get(c context.Context, id string) (result *Tournament, err error) {
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		var tournament Tournament
+		found, err := boltGet(tx.Bucket(tournamentBucket), []byte(id), &tournament)
+		if found {
+			result = &tournament
+		}
+		return err
+	})
+	return
+}
+
+func (self boltTournaments) All(c context.Context) (result Tournaments, err error) {
+	result = Tournaments{}
+	err = self.view(c, func(tx *bbolt.Tx) error {
+		return tx.Bucket(tournamentBucket).ForEach(func(k, v []byte) error {
+			var tournament Tournament
+			if err := common.MemCodec.Unmarshal(v, &tournament); err != nil {
+				return err
+			}
+			result = append(result, tournament)
+			return nil
+		})
+	})
+	return
+}
+
+func (self boltTournaments) Save(c context.Context, tournament *Tournament) error {
+	return self.update(c, func(tx *bbolt.Tx) (err error) {
+		bucket := tx.Bucket(tournamentBucket)
+		if tournament.Id == "" {
+			if tournament.Id, err = boltId(bucket); err != nil {
+				return
+			}
+		}
+		return boltPut(bucket, []byte(tournament.Id), tournament)
+	})
+}
@@ -0,0 +1,601 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	AIKind         = "AI"
+	AIErrorKind    = "AIError"
+	RatingKind     = "RatingChange"
+	GameKind       = "Game"
+	TurnKind       = "Turn"
+	MapKind        = "Map"
+	TournamentKind = "Tournament"
+	AllAIsKey      = "AIs{All}"
+	AllMapsKey     = "Maps{All}"
+	allGamesKey    = "Games{All}"
+)
+
+func aIByIdKey(k interface{}) string {
+	return fmt.Sprintf("AI{Id:%v}", k)
+}
+
+func aiErrorsKeyByParent(k interface{}) string {
+	return fmt.Sprintf("AIErrors{Parent:%v}", k)
+}
+
+func ratingsKeyByParent(k interface{}) string {
+	return fmt.Sprintf("RatingChanges{Parent:%v}", k)
+}
+
+func gameKeyForId(k interface{}) string {
+	return fmt.Sprintf("Game{Id:%v}", k)
+}
+
+func gamePageKey(limit, offset int) string {
+	return fmt.Sprintf("GamePage{limit:%v,offset:%v}", limit, offset)
+}
+
+func turnsKeyForParent(k interface{}) string {
+	return fmt.Sprintf("Turns{Parent:%v}", k)
+}
+
+func givenTurnKeyForParent(k interface{}, o interface{}) string {
+	return fmt.Sprintf("Turns{Parent:%v,Ordinal:%v}", k, o)
+}
+
+func latestTurnKeyForParent(k interface{}) string {
+	return fmt.Sprintf("Turns{Latest,Parent:%v}", k)
+}
+
+func tournamentKeyForId(k interface{}) string {
+	return fmt.Sprintf("Tournament{Id:%v}", k)
+}
+
+const allTournamentsKey = "Tournaments{All}"
+
+func mapByNameKey(k interface{}) string {
+	return fmt.Sprintf("Map{Name:%v}", k)
+}
+
+/*
+DatastoreStore keeps everything in the Google App Engine datastore, cached in memcache.
+
+Ids are encoded datastore keys.
+*/
+type DatastoreStore struct{}
+
+func (self DatastoreStore) Transaction(c context.Context, f func(context.Context) error) error {
+	return datastore.RunInTransaction(c, f, &datastore.TransactionOptions{XG: true})
+}
+
+func (self DatastoreStore) AIs() AIRepository {
+	return datastoreAIs{}
+}
+
+func (self DatastoreStore) AIErrors() AIErrorRepository {
+	return datastoreAIErrors{}
+}
+
+func (self DatastoreStore) Ratings() RatingRepository {
+	return datastoreRatings{}
+}
+
+func (self DatastoreStore) Games() GameRepository {
+	return datastoreGames{}
+}
+
+func (self DatastoreStore) Turns() TurnRepository {
+	return datastoreTurns{}
+}
+
+func (self DatastoreStore) Maps() MapRepository {
+	return datastoreMaps{}
+}
+
+func (self DatastoreStore) Tournaments() TournamentRepository {
+	return datastoreTournaments{}
+}
+
+func decodeKey(id string) (*datastore.Key, error) {
+	if id == "" {
+		return nil, nil
+	}
+	return datastore.DecodeKey(id)
+}
+
+func encodeKey(k *datastore.Key) string {
+	if k == nil {
+		return ""
+	}
+	return k.Encode()
+}
+
+type datastoreAIs struct{}
+
+func (self datastoreAIs) find(c context.Context, id string) (*AI, error) {
+	key, err := decodeKey(id)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	var ai AI
+	err = datastore.Get(c, key, &ai)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if !common.IsOkError(err) {
+		return nil, err
+	}
+	ai.Id = id
+	return &ai, nil
+}
+
+func (self datastoreAIs) Get(c context.Context, id string) (*AI, error) {
+	var ai AI
+	if common.Memoize(c, aIByIdKey(id), &ai, func() interface{} {
+		found, err := self.find(c, id)
+		common.AssertOkError(err)
+		return found
+	}) {
+		return &ai, nil
+	}
+	return nil, nil
+}
+
+func (self datastoreAIs) All(c context.Context) (result AIs, err error) {
+	common.Memoize(c, AllAIsKey, &result, func() interface{} {
+		var found AIs
+		ids, err := datastore.NewQuery(AIKind).GetAll(c, &found)
+		common.AssertOkError(err)
+		for index, id := range ids {
+			found[index].Id = id.Encode()
+		}
+		if found == nil {
+			found = AIs{}
+		}
+		return found
+	})
+	return
+}
+
+func (self datastoreAIs) Save(c context.Context, ai *AI) (err error) {
+	if ai.Id == "" {
+		var key *datastore.Key
+		if key, err = datastore.Put(c, datastore.NewKey(c, AIKind, "", 0, nil), ai); err != nil {
+			return
+		}
+		ai.Id = key.Encode()
+	} else {
+		var key *datastore.Key
+		if key, err = decodeKey(ai.Id); err != nil {
+			return
+		}
+		if _, err = datastore.Put(c, key, ai); err != nil {
+			return
+		}
+	}
+	common.MemDel(c, AllAIsKey, aIByIdKey(ai.Id))
+	return
+}
+
+func (self datastoreAIs) Delete(c context.Context, id string) (err error) {
+	key, err := decodeKey(id)
+	if err != nil {
+		return
+	}
+	if err = datastore.Delete(c, key); err != nil {
+		return
+	}
+	common.MemDel(c, AllAIsKey, aIByIdKey(id))
+	return
+}
+
+/*
+aiErrorEntity remembers the turn an error happened in as a datastore key, like it always has.
+*/
+type aiErrorEntity struct {
+	Turn *datastore.Key
+	AIError
+}
+
+type datastoreAIErrors struct{}
+
+func (self datastoreAIErrors) Add(c context.Context, aiId string, aiError *AIError) (err error) {
+	parent, err := decodeKey(aiId)
+	if err != nil {
+		return
+	}
+	gameKey, err := decodeKey(aiError.GameId)
+	if err != nil {
+		return
+	}
+	entity := &aiErrorEntity{
+		AIError: *aiError,
+	}
+	if gameKey != nil {
+		entity.Turn = datastore.NewKey(c, TurnKind, "", int64(aiError.TurnOrdinal)+1, gameKey)
+	}
+	if _, err = datastore.Put(c, datastore.NewKey(c, AIErrorKind, "", 0, parent), entity); err != nil {
+		return
+	}
+	common.MemDel(c, aiErrorsKeyByParent(aiId))
+	return
+}
+
+func (self datastoreAIErrors) Latest(c context.Context, aiId string, limit int) (result AIErrors, err error) {
+	parent, err := decodeKey(aiId)
+	if err != nil {
+		return
+	}
+	common.Memoize(c, aiErrorsKeyByParent(aiId), &result, func() interface{} {
+		var entities []aiErrorEntity
+		_, err := datastore.NewQuery(AIErrorKind).Ancestor(parent).Order("-CreatedAt").Limit(limit).GetAll(c, &entities)
+		common.AssertOkError(err)
+		found := make(AIErrors, len(entities))
+		for index, entity := range entities {
+			found[index] = entity.AIError
+			if entity.Turn != nil {
+				found[index].GameId = encodeKey(entity.Turn.Parent())
+			}
+		}
+		return found
+	})
+	return
+}
+
+type datastoreRatings struct{}
+
+func (self datastoreRatings) Add(c context.Context, aiId string, change *RatingChange) (err error) {
+	parent, err := decodeKey(aiId)
+	if err != nil {
+		return
+	}
+	if _, err = datastore.Put(c, datastore.NewKey(c, RatingKind, "", 0, parent), change); err != nil {
+		return
+	}
+	common.MemDel(c, ratingsKeyByParent(aiId))
+	return
+}
+
+func (self datastoreRatings) History(c context.Context, aiId string, limit int) (result RatingChanges, err error) {
+	parent, err := decodeKey(aiId)
+	if err != nil {
+		return
+	}
+	common.Memoize(c, ratingsKeyByParent(aiId), &result, func() interface{} {
+		var found RatingChanges
+		_, err := datastore.NewQuery(RatingKind).Ancestor(parent).Order("-CreatedAt").Limit(limit).GetAll(c, &found)
+		common.AssertOkError(err)
+		return found
+	})
+	return
+}
+
+/*
+gameEntity keeps the players and winner of a game as datastore keys, like it always has.
+
+Imported games have players from other hubs, which are kept as they are.
+*/
+type gameEntity struct {
+	Players         []*datastore.Key
+	Winner          *datastore.Key
+	ImportedPlayers []string
+	ImportedWinner  string
+	Game
+}
+
+func (self *gameEntity) game(id *datastore.Key) *Game {
+	result := self.Game
+	result.Id = id.Encode()
+	if result.Imported {
+		result.Players = self.ImportedPlayers
+		result.Winner = self.ImportedWinner
+		return &result
+	}
+	result.Players = make([]string, len(self.Players))
+	for index, player := range self.Players {
+		result.Players[index] = encodeKey(player)
+	}
+	result.Winner = encodeKey(self.Winner)
+	return &result
+}
+
+type datastoreGames struct{}
+
+func (self datastoreGames) find(c context.Context, id string) (*Game, error) {
+	key, err := decodeKey(id)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	var entity gameEntity
+	err = datastore.Get(c, key, &entity)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if !common.IsOkError(err) {
+		return nil, err
+	}
+	return entity.game(key), nil
+}
+
+func (self datastoreGames) Get(c context.Context, id string) (*Game, error) {
+	var game Game
+	if common.Memoize(c, gameKeyForId(id), &game, func() interface{} {
+		found, err := self.find(c, id)
+		common.AssertOkError(err)
+		return found
+	}) {
+		return &game, nil
+	}
+	return nil, nil
+}
+
+func (self datastoreGames) Page(c context.Context, offset, limit int) (result GamePage, err error) {
+	common.Memoize2(c, allGamesKey, gamePageKey(limit, offset), &result, func() interface{} {
+		var found GamePage
+		query := datastore.NewQuery(GameKind)
+		var err error
+		found.Total, err = query.Count(c)
+		common.AssertOkError(err)
+		var entities []gameEntity
+		ids, err := query.Limit(limit).Offset(offset).Order("-CreatedAt").GetAll(c, &entities)
+		common.AssertOkError(err)
+		found.Content = Games{}
+		for index, id := range ids {
+			found.Content = append(found.Content, *entities[index].game(id))
+		}
+		return found
+	})
+	return
+}
+
+func (self datastoreGames) Save(c context.Context, game *Game) (err error) {
+	entity := &gameEntity{
+		Game: *game,
+	}
+	if game.Imported {
+		entity.ImportedPlayers = game.Players
+		entity.ImportedWinner = game.Winner
+	} else {
+		entity.Players = make([]*datastore.Key, len(game.Players))
+		for index, player := range game.Players {
+			if entity.Players[index], err = decodeKey(player); err != nil {
+				return
+			}
+		}
+		if entity.Winner, err = decodeKey(game.Winner); err != nil {
+			return
+		}
+	}
+	var key *datastore.Key
+	if key, err = decodeKey(game.Id); err != nil {
+		return
+	}
+	if key == nil {
+		key = datastore.NewKey(c, GameKind, "", 0, nil)
+	}
+	if key, err = datastore.Put(c, key, entity); err != nil {
+		return
+	}
+	game.Id = key.Encode()
+	common.MemDel(c, allGamesKey, gameKeyForId(game.Id))
+	return
+}
+
+func (self datastoreGames) Unfinished(c context.Context) (result []string, err error) {
+	result = []string{}
+	for _, gameState := range []GameState{StateCreated, StatePlaying, StatePaused} {
+		var keys []*datastore.Key
+		if keys, err = datastore.NewQuery(GameKind).Filter("State=", string(gameState)).KeysOnly().GetAll(c, nil); err != nil {
+			return
+		}
+		for _, key := range keys {
+			result = append(result, key.Encode())
+		}
+	}
+	return
+}
+
+type datastoreTurns struct{}
+
+func (self datastoreTurns) one(c context.Context, query *datastore.Query) (*Turn, error) {
+	var turns Turns
+	if _, err := query.Limit(1).GetAll(c, &turns); !common.IsOkError(err) {
+		return nil, err
+	}
+	if len(turns) == 0 {
+		return nil, nil
+	}
+	return &turns[0], nil
+}
+
+func (self datastoreTurns) All(c context.Context, gameId string) (result Turns, err error) {
+	parent, err := decodeKey(gameId)
+	if err != nil {
+		return
+	}
+	common.Memoize(c, turnsKeyForParent(gameId), &result, func() interface{} {
+		var found Turns
+		_, err := datastore.NewQuery(TurnKind).Ancestor(parent).GetAll(c, &found)
+		common.AssertOkError(err)
+		if found == nil {
+			found = Turns{}
+		}
+		return found
+	})
+	return
+}
+
+func (self datastoreTurns) Get(c context.Context, gameId string, ordinal int) (*Turn, error) {
+	parent, err := decodeKey(gameId)
+	if err != nil {
+		return nil, err
+	}
+	var result Turn
+	if common.Memoize(c, givenTurnKeyForParent(gameId, ordinal), &result, func() interface{} {
+		found, err := self.one(c, datastore.NewQuery(TurnKind).Ancestor(parent).Filter("Ordinal=", ordinal))
+		common.AssertOkError(err)
+		return found
+	}) {
+		return &result, nil
+	}
+	return nil, nil
+}
+
+func (self datastoreTurns) Latest(c context.Context, gameId string) (*Turn, error) {
+	parent, err := decodeKey(gameId)
+	if err != nil {
+		return nil, err
+	}
+	var result Turn
+	if common.Memoize(c, latestTurnKeyForParent(gameId), &result, func() interface{} {
+		found, err := self.one(c, datastore.NewQuery(TurnKind).Ancestor(parent).Order("-CreatedAt"))
+		common.AssertOkError(err)
+		return found
+	}) {
+		return &result, nil
+	}
+	return nil, nil
+}
+
+/*
+Save stores turns with ordinal + 1 as id, so that a turn can't be stored twice.
+*/
+func (self datastoreTurns) Save(c context.Context, gameId string, turn *Turn) (err error) {
+	parent, err := decodeKey(gameId)
+	if err != nil {
+		return
+	}
+	if _, err = datastore.Put(c, datastore.NewKey(c, TurnKind, "", int64(turn.Ordinal)+1, parent), turn); err != nil {
+		return
+	}
+	common.MemDel(c, turnsKeyForParent(gameId), latestTurnKeyForParent(gameId), givenTurnKeyForParent(gameId, turn.Ordinal))
+	return
+}
+
+type datastoreMaps struct{}
+
+func (self datastoreMaps) Get(c context.Context, name string) (*Map, error) {
+	var m Map
+	if common.Memoize(c, mapByNameKey(name), &m, func() interface{} {
+		var found Map
+		err := datastore.Get(c, datastore.NewKey(c, MapKind, name, 0, nil), &found)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		common.AssertOkError(err)
+		return &found
+	}) {
+		return &m, nil
+	}
+	return nil, nil
+}
+
+func (self datastoreMaps) All(c context.Context) (result Maps, err error) {
+	common.Memoize(c, AllMapsKey, &result, func() interface{} {
+		var found Maps
+		_, err := datastore.NewQuery(MapKind).GetAll(c, &found)
+		common.AssertOkError(err)
+		if found == nil {
+			found = Maps{}
+		}
+		return found
+	})
+	return
+}
+
+func (self datastoreMaps) Save(c context.Context, m *Map) (err error) {
+	if _, err = datastore.Put(c, datastore.NewKey(c, MapKind, m.Name, 0, nil), m); err != nil {
+		return
+	}
+	common.MemDel(c, AllMapsKey, mapByNameKey(m.Name))
+	return
+}
+
+func (self datastoreMaps) Delete(c context.Context, name string) (err error) {
+	if err = datastore.Delete(c, datastore.NewKey(c, MapKind, name, 0, nil)); err != nil {
+		return
+	}
+	common.MemDel(c, AllMapsKey, mapByNameKey(name))
+	return
+}
+
+/*
+tournamentEntity keeps the schedule of a tournament as JSON, since the datastore can't keep nested slices.
+*/
+type tournamentEntity struct {
+	ScheduleBytes []byte
+	Tournament
+}
+
+func (self *tournamentEntity) tournament(id string) *Tournament {
+	result := self.Tournament
+	result.Id = id
+	if len(self.ScheduleBytes) > 0 {
+		common.AssertOkError(json.Unmarshal(self.ScheduleBytes, &result.Schedule))
+	}
+	return &result
+}
+
+type datastoreTournaments struct{}
+
+func (self datastoreTournaments) Get(c context.Context, id string) (result *Tournament, err error) {
+	key, err := decodeKey(id)
+	if err != nil || key == nil {
+		return
+	}
+	var tournament Tournament
+	if common.Memoize(c, tournamentKeyForId(id), &tournament, func() interface{} {
+		var entity tournamentEntity
+		err := datastore.Get(c, key, &entity)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		common.AssertOkError(err)
+		return entity.tournament(id)
+	}) {
+		result = &tournament
+	}
+	return
+}
+
+func (self datastoreTournaments) All(c context.Context) (result Tournaments, err error) {
+	common.Memoize(c, allTournamentsKey, &result, func() interface{} {
+		var entities []tournamentEntity
+		keys, err := datastore.NewQuery(TournamentKind).GetAll(c, &entities)
+		common.AssertOkError(err)
+		found := make(Tournaments, len(entities))
+		for index, entity := range entities {
+			found[index] = *entity.tournament(keys[index].Encode())
+		}
+		return found
+	})
+	return
+}
+
+func (self datastoreTournaments) Save(c context.Context, tournament *Tournament) (err error) {
+	entity := &tournamentEntity{
+		Tournament: *tournament,
+	}
+	if entity.ScheduleBytes, err = json.Marshal(tournament.Schedule); err != nil {
+		return
+	}
+	key := datastore.NewKey(c, TournamentKind, "", 0, nil)
+	if tournament.Id != "" {
+		if key, err = decodeKey(tournament.Id); err != nil {
+			return
+		}
+	}
+	if key, err = datastore.Put(c, key, entity); err != nil {
+		return
+	}
+	tournament.Id = key.Encode()
+	common.MemDel(c, allTournamentsKey, tournamentKeyForId(tournament.Id))
+	return
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"github.com/zond/stockholm-ai/state"
+	"golang.org/x/net/context"
+
+	ai "github.com/zond/stockholm-ai/ai"
+)
+
+/*
+DefaultMoveDeadline is how long AIs have to give their orders when neither game nor AI says otherwise.
+*/
+const DefaultMoveDeadline = 10 * time.Second
+
+/*
+LateOrderPolicy decides what orders an AI gives when it misses the move deadline, or fails to give orders at all.
+*/
+type LateOrderPolicy string
+
+const (
+	// LateOrdersEmpty makes the AI give no orders at all. It is the default.
+	LateOrdersEmpty LateOrderPolicy = "Empty"
+	// LateOrdersRepeat makes the AI give the same orders as last turn.
+	LateOrdersRepeat LateOrderPolicy = "Repeat"
+)
+
+func (self LateOrderPolicy) Validate() error {
+	switch self {
+	case "", LateOrdersEmpty, LateOrdersRepeat:
+		return nil
+	}
+	return fmt.Errorf("LateOrders must be %#v or %#v, not %#v", LateOrdersEmpty, LateOrdersRepeat, self)
+}
+
+/*
+Orders returns the orders player gives in the turn after lastTurn when it didn't manage to give any itself.
+*/
+func (self LateOrderPolicy) Orders(lastTurn *state.State, player state.PlayerId) state.Orders {
+	if self == LateOrdersRepeat {
+		return lastTurn.Orders[player]
+	}
+	return nil
+}
+
+/*
+TimeoutError is the error of AIs that didn't give their orders before the move deadline.
+*/
+type TimeoutError struct {
+	Deadline time.Duration
+}
+
+func (self TimeoutError) Error() string {
+	return fmt.Sprintf("No orders within the move deadline of %v", self.Deadline)
+}
+
+func millis(m int) time.Duration {
+	return time.Duration(m) * time.Millisecond
+}
+
+/*
+moveDeadline returns the shortest of the move deadlines of game and player, or DefaultMoveDeadline if neither has one.
+*/
+func moveDeadline(game *Game, player *AI) (result time.Duration) {
+	result = millis(game.MoveDeadlineMillis)
+	if aiDeadline := millis(player.MoveDeadlineMillis); aiDeadline > 0 && (result == 0 || aiDeadline < result) {
+		result = aiDeadline
+	}
+	if result == 0 {
+		result = DefaultMoveDeadline
+	}
+	return
+}
+
+type transportResult struct {
+	orders state.Orders
+	err    error
+}
+
+/*
+requestOrders asks player for orders using its transport, giving up after deadline, and returns how long it took.
+*/
+func requestOrders(c common.Context, player *AI, req ai.OrderRequest, deadline time.Duration) (orders state.Orders, latency time.Duration, err error) {
+	deadlineContext, cancel := context.WithTimeout(c, deadline)
+	defer cancel()
+	cpy := c
+	cpy.Context = deadlineContext
+	results := make(chan transportResult, 1)
+	start := time.Now()
+	go func() {
+		var result transportResult
+		defer func() {
+			if e := recover(); e != nil {
+				result.err = fmt.Errorf("%v", e)
+			}
+			results <- result
+		}()
+		result.orders, result.err = TransportFor(player).Orders(cpy, player, req)
+	}()
+	select {
+	case result := <-results:
+		orders, err = result.orders, result.err
+		if err != nil && deadlineContext.Err() != nil {
+			err = TimeoutError{
+				Deadline: deadline,
+			}
+		}
+	case <-deadlineContext.Done():
+		err = TimeoutError{
+			Deadline: deadline,
+		}
+	}
+	latency = time.Now().Sub(start)
+	return
+}
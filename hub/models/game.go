@@ -1,51 +1,23 @@
 package models
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"time"
 
 	"github.com/zond/stockholm-ai/hub/common"
 	"github.com/zond/stockholm-ai/state"
-	"golang.org/x/net/context"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/delay"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
 
 	ai "github.com/zond/stockholm-ai/ai"
-	aiCommon "github.com/zond/stockholm-ai/common"
 )
 
-const (
-	GameKind        = "Game"
-	allGamesKey     = "Games{All}"
-	maxGameDuration = 100
-)
-
-var nextTurnFunc *delay.Function
-
-func init() {
-	nextTurnFunc = delay.Func("models/game.nextTurnFunc", nextTurn)
-}
-
-func gameKeyForId(k interface{}) string {
-	return fmt.Sprintf("Game{Id:%v}", k)
-}
-
-func gamePageKey(limit, offset int) string {
-	return fmt.Sprintf("GamePage{limit:%v,offset:%v}", limit, offset)
-}
-
 type GameState string
 
 const (
 	StateCreated  GameState = "Created"
 	StatePlaying  GameState = "Playing"
 	StateFinished GameState = "Finished"
+	StatePaused   GameState = "Paused"
+	StateAborted  GameState = "Aborted"
 )
 
 type Games []Game
@@ -70,157 +42,184 @@ func (self Games) process(c common.Context) Games {
 }
 
 type Game struct {
-	Id          *datastore.Key
-	Players     []*datastore.Key
-	Winner      *datastore.Key
-	State       GameState
-	PlayerNames []string `datastore:"-"`
-	WinnerName  string   `datastore:"-"`
+	Id      string   `datastore:"-"`
+	Players []string `datastore:"-"`
+	Winner  string   `datastore:"-"`
+	State   GameState
+	// PlayerNames are the names of the players. They are only kept as they are for imported games, and looked up for other games.
+	PlayerNames []string
+	WinnerName  string `datastore:"-"`
 	Length      int
-	CreatedAt   time.Time
+	Seed        int64
+	Rules       state.Rules
+	Generator   string
+	Map         string
+	// MoveDeadlineMillis is how long each AI has to give its orders each turn, or 0 for DefaultMoveDeadline.
+	MoveDeadlineMillis int
+	LateOrders         LateOrderPolicy
+	// Eliminated contains the turn each player lost its last unit or resigned, or 0 if it hasn't. Eliminated players aren't asked for orders.
+	Eliminated []int
+	// Errors contain the number of turns each player failed to give orders in.
+	Errors []int
+	// Placements contain the final ranking of the players of finished games.
+	Placements state.Placements
+	// PendingSteps is the number of turns to run while paused.
+	PendingSteps int
+	// Tournament is the id of the tournament this game is part of, if any, and TournamentRound and TournamentMatch where in it the game is.
+	Tournament      string
+	TournamentRound int
+	TournamentMatch int
+	// Ladder is whether the matchmaker created this game.
+	Ladder bool
+	// Imported is whether this game was uploaded as a replay from another hub. Its players aren't AIs of this hub.
+	Imported  bool
+	Owner     string `json:"-"`
+	IsOwner   bool   `datastore:"-"`
+	CreatedAt time.Time
 }
 
-type orderResponse struct {
-	DatastorePlayerId *datastore.Key
-	StatePlayerId     state.PlayerId
-	Orders            state.Orders
-	Error             error
-}
-
-type orderError struct {
-	Request      *http.Request
-	RequestBody  string
-	Response     *http.Response
-	ResponseBody string
-}
+var errTurnAlreadyRun = fmt.Errorf("Turn already run")
+var errGameStopped = fmt.Errorf("Game stopped")
 
-func (self orderError) Error() string {
-	return fmt.Sprintf("Got %v from %v", self.Response.StatusCode, self.Request.URL)
+type orderResponse struct {
+	Index         int
+	StatePlayerId state.PlayerId
+	Orders        state.Orders
+	Latency       time.Duration
+	Error         error
 }
 
-func nextTurn(cont context.Context, id *datastore.Key, playerNames []string) {
-	con := common.Context{Context: cont}
+/*
+runTurn runs the next turn of a game, and returns whether the game goes on after it.
+*/
+func runTurn(con common.Context, id string) (goOn bool) {
 	self := getGameById(con, id)
-	self.PlayerNames = playerNames
-	if self.Length > maxGameDuration {
-		self.State = StateFinished
+	if self == nil {
+		common.Errorf(con, "No game %v to run a turn of", id)
+		return false
+	}
+	if !self.runnable() {
+		return false
+	}
+	self.setPlayerNames(con)
+	lastTurn := GetLatestTurnByParent(con, self.Id)
+	if self.Length > self.Rules.OrDefault().MaxTurns {
+		// games created before games ended as soon as they got too long
+		self.finish(lastTurn.State)
 		self.Save(con)
-		log.Infof(cont, "Ended %v due to timeout", self.Id)
-		return
+		self.recordResult(con)
+		self.reportToTournament(con)
+		common.Infof(con, "Ended %v due to timeout", self.Id)
+		return false
 	}
-	errorSavers := []func(){}
-	if err := common.Transaction(con, func(c common.Context) (err error) {
-		lastTurn := GetLatestTurnByParent(c, self.Id)
-		responses := make(chan orderResponse, len(self.Players))
-		ais := map[state.PlayerId]string{}
-		for index, playerId := range self.Players {
-			ais[state.PlayerId(playerId.Encode())] = self.PlayerNames[index]
+	responses := make(chan orderResponse, len(self.Players))
+	ais := map[state.PlayerId]string{}
+	for index, playerId := range self.Players {
+		ais[state.PlayerId(playerId)] = self.PlayerNames[index]
+	}
+	for index, playerId := range self.Players {
+		orderResp := orderResponse{
+			Index:         index,
+			StatePlayerId: state.PlayerId(playerId),
 		}
-		for _, playerId := range self.Players {
-			orderResp := orderResponse{
-				DatastorePlayerId: playerId,
-				StatePlayerId:     state.PlayerId(playerId.Encode()),
-			}
-			if foundAi := GetAIById(c, playerId); foundAi != nil {
-				go func() {
-					// Always deliver the order response
-					defer func() {
-						responses <- orderResp
-					}()
-
-					// create a request
-					orderRequest := ai.OrderRequest{
-						Me:          orderResp.StatePlayerId,
-						State:       lastTurn.State,
-						GameId:      state.GameId(self.Id.Encode()),
-						TurnOrdinal: lastTurn.Ordinal,
-						AIs:         ais,
-					}
-
-					// encode it into a body, and remember its string representation
-					sendBody := &bytes.Buffer{}
-					aiCommon.MustEncodeJSON(sendBody, orderRequest)
-					sendBodyString := sendBody.String()
-
-					// get a client
-					client := urlfetch.Client(c)
-
-					// send the request to the ai
-					req, err := http.NewRequest("POST", foundAi.URL, sendBody)
-					var resp *http.Response
-					if err == nil {
-						req.Header.Set("Content-Type", "application/json; charset=UTF-8")
-						resp, err = client.Do(req)
-					}
+		if index < len(self.Eliminated) && self.Eliminated[index] > 0 {
+			responses <- orderResp
+		} else if foundAi := GetAIById(con, playerId); foundAi != nil {
+			go func() {
+				// Always deliver the order response
+				defer func() {
+					responses <- orderResp
+				}()
 
-					recvBody := &bytes.Buffer{}
-					recvBodyString := ""
-					if err == nil {
-						// check what we received
-						_, err = io.Copy(recvBody, resp.Body)
-						recvBodyString = recvBody.String()
-					}
-					// if we have no other errors, but we got a non-200
-					if err == nil && resp.StatusCode != 200 {
-						err = orderError{
-							Request:      req,
-							RequestBody:  sendBodyString,
-							Response:     resp,
-							ResponseBody: recvBodyString,
-						}
-					}
+				// create a request
+				visible := lastTurn.State.VisibleTo(orderResp.StatePlayerId, self.Rules.OrDefault())
+				orderRequest := ai.OrderRequest{
+					Me:          orderResp.StatePlayerId,
+					State:       visible,
+					GameId:      state.GameId(self.Id),
+					TurnOrdinal: lastTurn.Ordinal,
+					AIs:         ais,
+					Rules:       self.Rules.OrDefault(),
+					Verdicts:    lastTurn.State.Verdicts[orderResp.StatePlayerId],
+					Events:      visible.Events,
+				}
 
-					// lets try to unserialize
-					if err == nil {
-						err = json.Unmarshal(recvBody.Bytes(), &orderResp.Orders)
-					}
+				// ask the ai for orders, in whatever way it wants to be asked, before the deadline
+				orders, latency, err := requestOrders(con, foundAi, orderRequest, moveDeadline(self, foundAi))
+				orderResp.Orders = orders
+				orderResp.Latency = latency
 
-					// store the error, if any
-					if err != nil {
-						orderResp.Error = err
-					}
-				}()
-			} else {
-				responses <- orderResp
-			}
+				// store the error, if any, and let the late order policy decide the orders
+				if err != nil {
+					orderResp.Error = err
+					orderResp.Orders = self.LateOrders.Orders(lastTurn.State, orderResp.StatePlayerId)
+				}
+			}()
+		} else {
+			responses <- orderResp
 		}
-		orderMap := map[state.PlayerId]state.Orders{}
-		for _, _ = range self.Players {
-			// wait for the responses
-			orderResp := <-responses
-			// store it
-			orderMap[orderResp.StatePlayerId] = orderResp.Orders
-			// if we got an error
-			if orderResp.Error != nil {
-				// make sure to save it later
-				errorSavers = append(errorSavers, func() {
-					if ai := GetAIById(con, orderResp.DatastorePlayerId); ai != nil {
-						ai.AddError(con, lastTurn.Id, orderResp.Error)
-					}
-				})
-			}
+	}
+	orderMap := map[state.PlayerId]state.Orders{}
+	failed := map[state.PlayerId]bool{}
+	latencies := make([]time.Duration, len(self.Players))
+	errorSavers := []func(){}
+	for _, _ = range self.Players {
+		// wait for the responses
+		orderResp := <-responses
+		// store it, and how long it took
+		orderMap[orderResp.StatePlayerId] = orderResp.Orders
+		latencies[orderResp.Index] = orderResp.Latency
+		// if we got an error
+		if orderResp.Error != nil {
+			failed[orderResp.StatePlayerId] = true
+			// make sure to save it later
+			errorSavers = append(errorSavers, func() {
+				if ai := GetAIById(con, string(orderResp.StatePlayerId)); ai != nil {
+					ai.AddError(con, self.Id, lastTurn.Ordinal, orderResp.Latency, orderResp.Error)
+				}
+			})
+		}
+	}
+	// execute the orders
+	newTurn, winner := lastTurn.Next(con, orderMap)
+	newTurn.Latencies = latencies
+	if err := transaction(con, func(c common.Context) (err error) {
+		// make sure nobody else ran this turn while we waited for the orders
+		if latest := GetLatestTurnByParent(c, self.Id); latest == nil || latest.Ordinal != lastTurn.Ordinal {
+			return errTurnAlreadyRun
+		}
+		// make sure nobody paused or aborted us while we waited for the orders
+		current := getGameById(c, self.Id)
+		if !current.runnable() {
+			return errGameStopped
 		}
-		// execute the orders
-		newTurn, winner := lastTurn.Next(c, orderMap)
 		// save the new turn
 		newTurn.Save(c, self.Id)
-		// if we got a winner, end the game and store the winner
-		if winner == nil {
-			self.State = StatePlaying
+		// remember when players lost their last unit, and who failed to give orders
+		current.eliminate(newTurn.State)
+		current.countErrors(failed)
+		// increase our length with the new turn
+		current.Length += 1
+		// if we got a winner, or got too long, end the game and rank the players
+		if winner != nil || current.Length > current.Rules.OrDefault().MaxTurns {
+			current.finish(newTurn.State)
+		} else if current.State == StatePaused {
+			current.PendingSteps -= 1
 		} else {
-			self.Winner = common.MustDecodeKey(string(*winner))
-			self.State = StateFinished
+			current.State = StatePlaying
 		}
-		// increase our length with the new turn
-		self.Length += 1
 		// save us
-		self.Save(c)
-		// if we didn't end, queue the next turn
-		if winner == nil {
-			nextTurnFunc.Call(c, self.Id, playerNames)
-		}
+		current.PlayerNames = self.PlayerNames
+		current.Save(c)
+		self = current
 		return nil
-	}); err != nil {
+	}); err == errTurnAlreadyRun {
+		common.Infof(con, "Turn %v of %v was already run", lastTurn.Ordinal, self.Id)
+		return false
+	} else if err == errGameStopped {
+		common.Infof(con, "Discarded turn %v of %v since the game was stopped", lastTurn.Ordinal, self.Id)
+		return false
+	} else if err != nil {
 		panic(err)
 	}
 	// run any error savers we got
@@ -229,28 +228,218 @@ func nextTurn(cont context.Context, id *datastore.Key, playerNames []string) {
 	}
 	// store the new stats in the players if we ended
 	if self.State == StateFinished {
-		for _, playerId := range self.Players {
-			common.Transaction(con, func(c common.Context) error {
-				if ai := GetAIById(c, playerId); ai != nil {
-					if playerId.Equal(self.Winner) {
-						ai.Wins += 1
+		self.recordResult(con)
+		self.reportToTournament(con)
+	}
+	return self.runnable()
+}
+
+/*
+eliminate remembers the turns s says players were eliminated in, unless they were eliminated earlier. Games played before states tracked eliminations already know about the players eliminated before then.
+*/
+func (self *Game) eliminate(s *state.State) {
+	for len(self.Eliminated) < len(self.Players) {
+		self.Eliminated = append(self.Eliminated, 0)
+	}
+	for index, playerId := range self.Players {
+		if self.Eliminated[index] == 0 {
+			self.Eliminated[index] = s.Eliminated[state.PlayerId(playerId)]
+		}
+	}
+}
+
+/*
+countErrors remembers that the failed players failed to give orders in one more turn.
+*/
+func (self *Game) countErrors(failed map[state.PlayerId]bool) {
+	for len(self.Errors) < len(self.Players) {
+		self.Errors = append(self.Errors, 0)
+	}
+	for index, playerId := range self.Players {
+		if failed[state.PlayerId(playerId)] {
+			self.Errors[index] += 1
+		}
+	}
+}
+
+/*
+errorRates returns the share of turns of self each player failed to give orders in.
+*/
+func (self *Game) errorRates() (result map[state.PlayerId]float64) {
+	result = map[state.PlayerId]float64{}
+	turns := self.Length - 1
+	if turns < 1 {
+		turns = 1
+	}
+	for index, playerId := range self.Players {
+		if index < len(self.Errors) {
+			result[state.PlayerId(playerId)] = float64(self.Errors[index]) / float64(turns)
+		}
+	}
+	return
+}
+
+/*
+finish ends self, ranking the players by finalState. The winner is the only player ranked first, if any.
+*/
+func (self *Game) finish(finalState *state.State) {
+	playerIds := make([]state.PlayerId, len(self.Players))
+	eliminated := map[state.PlayerId]int{}
+	for index, playerId := range self.Players {
+		playerIds[index] = state.PlayerId(playerId)
+		if index < len(self.Eliminated) {
+			eliminated[state.PlayerId(playerId)] = self.Eliminated[index]
+		}
+	}
+	self.State = StateFinished
+	self.Placements = finalState.Ranking(playerIds, eliminated)
+	self.Winner = ""
+	if winners := self.Placements.Winners(); len(winners) == 1 {
+		self.Winner = string(winners[0])
+	}
+}
+
+/*
+recordResult adds the placements and error rates of the finished game self to the stats and ratings of its players.
+*/
+func (self *Game) recordResult(con common.Context) {
+	draw := len(self.Placements.Winners()) > 1
+	errorRates := self.errorRates()
+	for _, placement := range self.Placements {
+		transaction(con, func(c common.Context) error {
+			if ai := GetAIById(c, string(placement.Player)); ai != nil {
+				ai.addErrorRate(c, errorRates[placement.Player])
+				if placement.Rank == 1 {
+					if draw {
+						ai.Draws += 1
 					} else {
-						ai.Losses += 1
+						ai.Wins += 1
 					}
-					ai.Save(c)
+				} else {
+					ai.Losses += 1
 				}
-				return nil
-			})
+				for len(ai.Placements) < placement.Rank {
+					ai.Placements = append(ai.Placements, 0)
+				}
+				ai.Placements[placement.Rank-1] += 1
+				ai.Save(c)
+			}
+			return nil
+		})
+	}
+	self.rate(con)
+}
+
+/*
+runnable returns whether the next turn of self should run.
+*/
+func (self *Game) runnable() bool {
+	switch self.State {
+	case StateCreated, StatePlaying:
+		return true
+	case StatePaused:
+		return self.PendingSteps > 0
+	}
+	return false
+}
+
+/*
+control changes the state of the game with the given id using f inside a transaction, and schedules its next turn if that makes it runnable.
+*/
+func control(c common.Context, id string, f func(*Game) error) (result *Game, err error) {
+	if err = transaction(c, func(c common.Context) error {
+		if result = getGameById(c, id); result == nil {
+			return fmt.Errorf("No game %v", id)
+		}
+		if err := f(result); err != nil {
+			return err
+		}
+		result.Save(c)
+		return nil
+	}); err != nil {
+		return
+	}
+	if result.runnable() {
+		err = scheduler.Schedule(c, id)
+	}
+	result.process(c)
+	return
+}
+
+/*
+PauseGame stops the game with the given id from running more turns until it is resumed or stepped.
+*/
+func PauseGame(c common.Context, id string) (*Game, error) {
+	return control(c, id, func(game *Game) error {
+		if game.State != StateCreated && game.State != StatePlaying {
+			return fmt.Errorf("Can't pause %v games", game.State)
+		}
+		game.State = StatePaused
+		game.PendingSteps = 0
+		return nil
+	})
+}
+
+/*
+ResumeGame makes the paused game with the given id run turns again.
+*/
+func ResumeGame(c common.Context, id string) (*Game, error) {
+	return control(c, id, func(game *Game) error {
+		if game.State != StatePaused {
+			return fmt.Errorf("Can't resume %v games", game.State)
+		}
+		game.State = StatePlaying
+		game.PendingSteps = 0
+		return nil
+	})
+}
+
+/*
+StepGame makes the paused game with the given id run exactly one more turn, and then stay paused.
+*/
+func StepGame(c common.Context, id string) (*Game, error) {
+	return control(c, id, func(game *Game) error {
+		if game.State != StatePaused {
+			return fmt.Errorf("Can't step %v games", game.State)
 		}
+		game.PendingSteps += 1
+		return nil
+	})
+}
+
+/*
+AbortGame ends the game with the given id without a winner, and without changing the stats of its players.
+
+Aborted tournament games give no points to either player, and get replayed.
+*/
+func AbortGame(c common.Context, id string) (result *Game, err error) {
+	if result, err = control(c, id, func(game *Game) error {
+		if game.State == StateFinished || game.State == StateAborted {
+			return fmt.Errorf("Can't abort %v games", game.State)
+		}
+		game.State = StateAborted
+		game.PendingSteps = 0
+		return nil
+	}); err == nil {
+		result.reportToTournament(c)
 	}
+	return
 }
 
 func (self *Game) setPlayerNames(c common.Context) {
+	if self.Imported {
+		for index, id := range self.Players {
+			if id == self.Winner && index < len(self.PlayerNames) {
+				self.WinnerName = self.PlayerNames[index]
+			}
+		}
+		return
+	}
 	self.PlayerNames = make([]string, len(self.Players))
 	for index, id := range self.Players {
 		if ai := GetAIById(c, id); ai != nil {
 			self.PlayerNames[index] = ai.Name
-			if ai.Id.Equal(self.Winner) {
+			if ai.Id == self.Winner {
 				self.WinnerName = ai.Name
 			}
 		} else {
@@ -261,6 +450,7 @@ func (self *Game) setPlayerNames(c common.Context) {
 
 func (self *Game) process(c common.Context) *Game {
 	self.setPlayerNames(c)
+	self.IsOwner = c.User != nil && self.Owner == c.User.Email
 	return self
 }
 
@@ -269,53 +459,20 @@ type GamePage struct {
 	Total   int
 }
 
-func findGamePage(c common.Context, offset, limit int) (result GamePage) {
-	query := datastore.NewQuery(GameKind)
-	var err error
-	result.Total, err = query.Count(c)
-	common.AssertOkError(err)
-	var ids []*datastore.Key
-	ids, err = query.Limit(limit).Offset(offset).Order("-CreatedAt").GetAll(c, &result.Content)
-	common.AssertOkError(err)
-	for index, id := range ids {
-		result.Content[index].Id = id
-	}
-	if result.Content == nil {
-		result.Content = Games{}
-	}
-	return
-}
-
 func GetGamePage(c common.Context, offset, limit int) (result GamePage) {
-	common.Memoize2(c, allGamesKey, gamePageKey(limit, offset), &result, func() interface{} {
-		return findGamePage(c, offset, limit)
-	})
+	result, err := store.Games().Page(c, offset, limit)
+	common.AssertOkError(err)
 	result.Content.process(c)
 	return result
 }
 
-func findGameById(c common.Context, id *datastore.Key) *Game {
-	var game Game
-	err := datastore.Get(c, id, &game)
-	if err == datastore.ErrNoSuchEntity {
-		return nil
-	}
+func getGameById(c common.Context, id string) *Game {
+	game, err := store.Games().Get(c, id)
 	common.AssertOkError(err)
-	game.Id = id
-	return &game
+	return game
 }
 
-func getGameById(c common.Context, id *datastore.Key) *Game {
-	var game Game
-	if common.Memoize(c, gameKeyForId(id), &game, func() interface{} {
-		return findGameById(c, id)
-	}) {
-		return &game
-	}
-	return nil
-}
-
-func GetGameById(c common.Context, id *datastore.Key) (result *Game) {
+func GetGameById(c common.Context, id string) (result *Game) {
 	result = getGameById(c, id)
 	if result != nil {
 		result.process(c)
@@ -325,30 +482,57 @@ func GetGameById(c common.Context, id *datastore.Key) (result *Game) {
 
 func (self *Game) Save(c common.Context) *Game {
 	var err error
-	if self.Id == nil {
+	if self.Id == "" {
+		// new games start from scratch, whatever the client sent. Only ImportReplay creates imported games, and it doesn't use Save.
+		self.Eliminated = nil
+		self.Errors = nil
+		self.Placements = nil
+		self.Winner = ""
+		self.WinnerName = ""
+		self.Imported = false
+		self.PlayerNames = nil
 		self.setPlayerNames(c)
-		err = common.Transaction(c, func(c common.Context) (err error) {
+		err = transaction(c, func(c common.Context) (err error) {
 			self.CreatedAt = time.Now()
 			self.State = StateCreated
+			self.PendingSteps = 0
 			self.Length = 1
-			self.Id, err = datastore.Put(c, datastore.NewKey(c, GameKind, "", 0, nil), self)
-			if err != nil {
+			if self.Seed == 0 {
+				self.Seed = self.CreatedAt.UnixNano()
+			}
+			self.Rules = self.Rules.OrDefault()
+			if err = store.Games().Save(c, self); err != nil {
 				return
 			}
 			playerIds := make([]state.PlayerId, 0, len(self.Players))
 			for _, id := range self.Players {
-				playerIds = append(playerIds, state.PlayerId(id.Encode()))
+				playerIds = append(playerIds, state.PlayerId(id))
 			}
-			turn := &Turn{
-				State: state.RandomState(common.GAELogger{c}, playerIds),
+			turn := &Turn{}
+			if self.Map == "" {
+				var generator state.MapGenerator
+				if generator, err = state.GetMapGenerator(self.Generator); err != nil {
+					return
+				}
+				if turn.State, err = state.GenerateState(common.PlatformLogger{Context: c}, generator, self.Seed, self.Rules, playerIds); err != nil {
+					return
+				}
+			} else {
+				m := GetMapByName(c, self.Map)
+				if m == nil {
+					return fmt.Errorf("No map named %#v", self.Map)
+				}
+				if turn.State, err = m.Map.State(self.Rules, playerIds); err != nil {
+					return
+				}
 			}
 			turn.Save(c, self.Id)
-			nextTurnFunc.Call(c, self.Id, self.PlayerNames)
 			return nil
 		})
 		if err == nil {
+			err = scheduler.Schedule(c, self.Id)
 			for _, playerId := range self.Players {
-				common.Transaction(c, func(common.Context) error {
+				transaction(c, func(c common.Context) error {
 					if ai := GetAIById(c, playerId); ai != nil {
 						ai.Games += 1
 						ai.Save(c)
@@ -358,9 +542,8 @@ func (self *Game) Save(c common.Context) *Game {
 			}
 		}
 	} else {
-		_, err = datastore.Put(c, self.Id, self)
+		err = store.Games().Save(c, self)
 	}
 	common.AssertOkError(err)
-	common.MemDel(c, allGamesKey, gameKeyForId(self.Id))
 	return self.process(c)
 }
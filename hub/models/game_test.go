@@ -0,0 +1,75 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"github.com/zond/stockholm-ai/state"
+	"golang.org/x/net/context"
+
+	ai "github.com/zond/stockholm-ai/ai"
+	aiCommon "github.com/zond/stockholm-ai/common"
+)
+
+type manualScheduler struct{}
+
+func (self manualScheduler) Schedule(c common.Context, gameId string) error {
+	return nil
+}
+
+func (self manualScheduler) Stop() {
+}
+
+type sleeper time.Duration
+
+func (self sleeper) Orders(logger aiCommon.Logger, req ai.OrderRequest) state.Orders {
+	time.Sleep(time.Duration(self))
+	return nil
+}
+
+func withBoltStore(t *testing.T, f func(c common.Context)) {
+	platform, err := common.NewSelfHostedPlatform("")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	common.SetPlatform(platform)
+	defer common.SetPlatform(common.GAEPlatform{})
+	boltStore, err := NewBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer boltStore.Close()
+	UseStore(boltStore)
+	defer UseStore(DatastoreStore{})
+	UseScheduler(manualScheduler{})
+	defer UseScheduler(TaskQueueScheduler{Queue: "games"})
+	f(common.Context{Context: context.Background()})
+}
+
+func TestRunTurnLatencies(t *testing.T) {
+	ai.Register("test-sleeper", sleeper(time.Millisecond))
+	withBoltStore(t, func(c common.Context) {
+		players := []string{}
+		for _, name := range []string{"a", "b"} {
+			players = append(players, (&AI{Name: name, URL: LocalScheme + "test-sleeper"}).Save(c).Id)
+		}
+		game := (&Game{Players: players}).Save(c)
+		if !runTurn(c, game.Id) {
+			t.Fatalf("Wanted the game to go on after the first turn")
+		}
+		turn := GetLatestTurnByParent(c, game.Id)
+		if turn.Ordinal != 1 || len(turn.Latencies) != len(players) {
+			t.Fatalf("Wanted turn 1 with a latency per player, but got %v with %v", turn.Ordinal, turn.Latencies)
+		}
+		for index, latency := range turn.Latencies {
+			if latency < time.Millisecond || latency > DefaultMoveDeadline {
+				t.Fatalf("Wanted %v to take between %v and %v, but got %v", players[index], time.Millisecond, DefaultMoveDeadline, latency)
+			}
+			if errors := GetAIById(c, players[index]).GetErrors(c); len(errors) != 0 {
+				t.Fatalf("Wanted no errors for %v, but got %+v", players[index], errors)
+			}
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"golang.org/x/net/context"
+)
+
+const (
+	// ErrorRateWeight is how much the error rate of the latest game counts in the moving average ErrorRate of AIs.
+	ErrorRateWeight = 0.5
+	// LadderMaxErrorRate is the ErrorRate above which AIs get suspended from the ladder.
+	LadderMaxErrorRate = 0.5
+	// LadderOpponents is the number of AIs with the closest ratings the matchmaker randomly picks an opponent among.
+	LadderOpponents = 3
+	// LadderClaim is how long an AI counts as busy after the matchmaker picked it, even if its game can't be found yet.
+	LadderClaim = time.Minute
+)
+
+/*
+addErrorRate adds the error rate of a finished game to the moving average ErrorRate of self, and suspends self from the ladder if it gets too high.
+*/
+func (self *AI) addErrorRate(c common.Context, errorRate float64) {
+	self.ErrorRate = self.ErrorRate*(1-ErrorRateWeight) + errorRate*ErrorRateWeight
+	if self.Ladder && !self.Suspended && self.ErrorRate > LadderMaxErrorRate {
+		self.Suspended = true
+		common.Infof(c, "Suspended %v from the ladder due to an error rate of %v", self.Id, self.ErrorRate)
+	}
+}
+
+/*
+SetLadder enrolls the AI with the given id in the ladder, lifting any suspension, or takes it out of it.
+*/
+func SetLadder(c common.Context, id string, ladder bool) (result *AI) {
+	common.AssertOkError(transaction(c, func(c common.Context) error {
+		if result = GetAIById(c, id); result != nil {
+			result.Ladder = ladder
+			if ladder && result.Suspended {
+				result.Suspended = false
+				result.ErrorRate = 0
+			}
+			result.Save(c)
+		}
+		return nil
+	}))
+	if result != nil {
+		result.process(c)
+	}
+	return
+}
+
+/*
+ladderCandidates sorts AIs by how long ago they last got a ladder game, longest first.
+*/
+type ladderCandidates AIs
+
+func (self ladderCandidates) Len() int {
+	return len(self)
+}
+
+func (self ladderCandidates) Less(i, j int) bool {
+	return self[i].LastLadderGame.Before(self[j].LastLadderGame)
+}
+
+func (self ladderCandidates) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+ladderPlayers returns the candidate that waited longest for a ladder game and one of the LadderOpponents candidates with the closest ratings to it, in random order, or nil if there aren't two candidates.
+*/
+func ladderPlayers(random *rand.Rand, candidates ladderCandidates) (result []string) {
+	if len(candidates) < 2 {
+		return
+	}
+	sort.Sort(candidates)
+	player := candidates[0]
+	opponents := append(ladderCandidates{}, candidates[1:]...)
+	sort.SliceStable(opponents, func(i, j int) bool {
+		return math.Abs(opponents[i].Rating.OrDefault().Mu-player.Rating.OrDefault().Mu) < math.Abs(opponents[j].Rating.OrDefault().Mu-player.Rating.OrDefault().Mu)
+	})
+	if len(opponents) > LadderOpponents {
+		opponents = opponents[:LadderOpponents]
+	}
+	opponent := opponents[random.Intn(len(opponents))]
+	result = []string{player.Id, opponent.Id}
+	random.Shuffle(len(result), func(i, j int) {
+		result[i], result[j] = result[j], result[i]
+	})
+	return
+}
+
+/*
+MatchLadder creates a game between the enrolled, unsuspended AI that waited longest for a ladder game and one of the AIs with the closest ratings, unless either is already playing a ladder game. Random choices are made using random.
+
+The picked AIs are checked and claimed in a single transaction, so that overlapping calls never put an AI in more than one ladder game. It returns nil if there weren't two AIs to match.
+*/
+func MatchLadder(c common.Context, random *rand.Rand) (result *Game, err error) {
+	busy := map[string]bool{}
+	ids, err := store.Games().Unfinished(c)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		if game := getGameById(c, id); game != nil && game.Ladder {
+			for _, playerId := range game.Players {
+				busy[playerId] = true
+			}
+		}
+	}
+	all, err := store.AIs().All(c)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	candidates := ladderCandidates{}
+	for _, ai := range all {
+		if ai.Ladder && !ai.Suspended && !busy[ai.Id] && now.Sub(ai.LastLadderGame) >= LadderClaim {
+			candidates = append(candidates, ai)
+		}
+	}
+	players := ladderPlayers(random, candidates)
+	if players == nil {
+		return
+	}
+	claimed := false
+	if err = transaction(c, func(c common.Context) error {
+		claimed = false
+		ais := []*AI{}
+		for _, id := range players {
+			ai := GetAIById(c, id)
+			if ai == nil || !ai.Ladder || ai.Suspended || now.Sub(ai.LastLadderGame) < LadderClaim {
+				return nil
+			}
+			ais = append(ais, ai)
+		}
+		for _, ai := range ais {
+			ai.LastLadderGame = now
+			ai.Save(c)
+		}
+		claimed = true
+		return nil
+	}); err != nil || !claimed {
+		return
+	}
+	result = (&Game{
+		Players: players,
+		Ladder:  true,
+	}).Save(c)
+	common.Infof(c, "Matched %v in %v", result.PlayerNames, result.Id)
+	return
+}
+
+/*
+Matchmaker runs MatchLadder regularly, for hubs without cron jobs.
+*/
+type Matchmaker struct {
+	random  *rand.Rand
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+/*
+NewMatchmaker returns a matchmaker running MatchLadder every interval.
+*/
+func NewMatchmaker(c context.Context, interval time.Duration) (result *Matchmaker) {
+	result = &Matchmaker{
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:   make(chan struct{}),
+	}
+	result.stopped.Add(1)
+	go func() {
+		defer result.stopped.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-result.stop:
+				return
+			case <-ticker.C:
+				result.match(common.Context{Context: c})
+			}
+		}
+	}()
+	return
+}
+
+func (self *Matchmaker) match(c common.Context) {
+	defer func() {
+		if e := recover(); e != nil {
+			common.Errorf(c, "Matching the ladder panicked with %v", e)
+		}
+	}()
+	if _, err := MatchLadder(c, self.random); err != nil {
+		common.Errorf(c, "Matching the ladder failed with %v", err)
+	}
+}
+
+/*
+Stop stops matching, and waits for any running match to finish.
+*/
+func (self *Matchmaker) Stop() {
+	close(self.stop)
+	self.stopped.Wait()
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zond/stockholm-ai/rating"
+)
+
+func TestLadderPlayers(t *testing.T) {
+	now := time.Now()
+	candidates := ladderCandidates{
+		{Id: "recent", LastLadderGame: now, Rating: rating.Rating{Mu: 25, Sigma: 1}},
+		{Id: "waiting", LastLadderGame: now.Add(-time.Hour), Rating: rating.Rating{Mu: 25, Sigma: 1}},
+		{Id: "close", LastLadderGame: now.Add(-time.Minute), Rating: rating.Rating{Mu: 26, Sigma: 1}},
+		{Id: "closer", LastLadderGame: now.Add(-time.Minute), Rating: rating.Rating{Mu: 25.5, Sigma: 1}},
+		{Id: "far", LastLadderGame: now.Add(-time.Minute), Rating: rating.Rating{Mu: 40, Sigma: 1}},
+	}
+	opponents := map[string]bool{}
+	for seed := int64(0); seed < 100; seed++ {
+		found := ladderPlayers(rand.New(rand.NewSource(seed)), append(ladderCandidates{}, candidates...))
+		if again := ladderPlayers(rand.New(rand.NewSource(seed)), append(ladderCandidates{}, candidates...)); !reflect.DeepEqual(found, again) {
+			t.Fatalf("Wanted the same seed to give the same players, but got %v and %v", found, again)
+		}
+		if len(found) != 2 {
+			t.Fatalf("Wanted 2 players, but got %v", found)
+		}
+		opponent := found[0]
+		if found[0] == "waiting" {
+			opponent = found[1]
+		} else if found[1] != "waiting" {
+			t.Fatalf("Wanted the AI that waited longest to play, but got %v", found)
+		}
+		opponents[opponent] = true
+	}
+	if wanted := map[string]bool{"recent": true, "close": true, "closer": true}; !reflect.DeepEqual(opponents, wanted) {
+		t.Fatalf("Wanted the %v closest rated AIs as opponents, but got %v", LadderOpponents, opponents)
+	}
+	if found := ladderPlayers(rand.New(rand.NewSource(0)), candidates[:1]); found != nil {
+		t.Fatalf("Wanted no players for a single candidate, but got %v", found)
+	}
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"github.com/zond/stockholm-ai/state"
+)
+
+type Maps []Map
+
+func (self Maps) Len() int {
+	return len(self)
+}
+
+func (self Maps) Less(i, j int) bool {
+	return self[i].Name < self[j].Name
+}
+
+func (self Maps) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+func (self Maps) process(c common.Context) Maps {
+	for index, _ := range self {
+		(&self[index]).process(c)
+	}
+	return self
+}
+
+/*
+Map is a named map in the map pool, stored in the state.Map file format.
+*/
+type Map struct {
+	Name          string
+	Players       int
+	SerializedMap []byte     `json:"-"`
+	Map           *state.Map `datastore:"-"`
+	Owner         string     `json:"-"`
+	IsOwner       bool       `datastore:"-"`
+	CreatedAt     time.Time
+}
+
+func (self *Map) process(c common.Context) *Map {
+	if len(self.SerializedMap) > 0 {
+		var err error
+		self.Map, err = state.LoadMap(bytes.NewBuffer(self.SerializedMap))
+		common.AssertOkError(err)
+	}
+	if c.User != nil {
+		self.IsOwner = self.Owner == c.User.Email
+	}
+	return self
+}
+
+func GetMapByName(c common.Context, name string) *Map {
+	m, err := store.Maps().Get(c, name)
+	common.AssertOkError(err)
+	if m == nil {
+		return nil
+	}
+	return m.process(c)
+}
+
+func GetAllMaps(c common.Context) (result Maps) {
+	result, err := store.Maps().All(c)
+	common.AssertOkError(err)
+	sort.Sort(result)
+	return result.process(c)
+}
+
+func (self *Map) Delete(c common.Context) {
+	common.AssertOkError(store.Maps().Delete(c, self.Name))
+}
+
+/*
+Save stores self in the map pool under the name of its map, replacing any earlier map with that name.
+*/
+func (self *Map) Save(c common.Context) *Map {
+	buf := &bytes.Buffer{}
+	common.AssertOkError(state.SaveMap(buf, self.Map))
+	self.SerializedMap = buf.Bytes()
+	self.Name = self.Map.Name
+	self.Players = len(self.Map.Starts)
+	if self.CreatedAt.IsZero() {
+		self.CreatedAt = time.Now()
+	}
+	common.AssertOkError(store.Maps().Save(c, self))
+	return self.process(c)
+}
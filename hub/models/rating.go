@@ -0,0 +1,112 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"github.com/zond/stockholm-ai/rating"
+)
+
+/*
+RatingChange is how the rating of an AI changed after a game.
+*/
+type RatingChange struct {
+	GameId string
+	// Rank is the rank of the AI in the game.
+	Rank int
+	// Players is the number of players in the game.
+	Players   int
+	Before    rating.Rating
+	After     rating.Rating
+	CreatedAt time.Time
+}
+
+type RatingChanges []RatingChange
+
+func (self RatingChanges) Len() int {
+	return len(self)
+}
+
+func (self RatingChanges) Less(j, i int) bool {
+	return self[i].CreatedAt.Before(self[j].CreatedAt)
+}
+
+func (self RatingChanges) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+Leaderboard sorts AIs by conservative rating, best first.
+*/
+type Leaderboard AIs
+
+func (self Leaderboard) Len() int {
+	return len(self)
+}
+
+func (self Leaderboard) Less(i, j int) bool {
+	return self[i].Rating.OrDefault().Conservative() > self[j].Rating.OrDefault().Conservative()
+}
+
+func (self Leaderboard) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+GetLeaderboard returns all AIs, best rated first.
+*/
+func GetLeaderboard(c common.Context) (result Leaderboard) {
+	all, err := store.AIs().All(c)
+	common.AssertOkError(err)
+	sort.Sort(AIs(all))
+	result = Leaderboard(all.process(c))
+	sort.Stable(result)
+	return
+}
+
+func (self *AI) GetRatingHistory(c common.Context) (result RatingChanges) {
+	result, err := store.Ratings().History(c, self.Id, 100)
+	common.AssertOkError(err)
+	sort.Sort(result)
+	return
+}
+
+/*
+rate updates the ratings of the players of the finished game self according to their placements, and remembers the changes.
+
+The ratings are read, updated and written in one transaction, so that games finishing at the same time don't overwrite each others rating changes.
+*/
+func (self *Game) rate(con common.Context) {
+	common.AssertOkError(transaction(con, func(c common.Context) error {
+		ais := make([]*AI, len(self.Placements))
+		ratings := make([]rating.Rating, len(self.Placements))
+		ranks := make([]int, len(self.Placements))
+		for index, placement := range self.Placements {
+			if ais[index] = GetAIById(c, string(placement.Player)); ais[index] != nil {
+				ratings[index] = ais[index].Rating
+			}
+			ranks[index] = placement.Rank
+		}
+		updated := rating.Update(ratings, ranks)
+		now := time.Now()
+		for index, placement := range self.Placements {
+			if ai := ais[index]; ai != nil {
+				change := &RatingChange{
+					GameId:    self.Id,
+					Rank:      placement.Rank,
+					Players:   len(self.Placements),
+					Before:    ratings[index].OrDefault(),
+					After:     updated[index],
+					CreatedAt: now,
+				}
+				ai.Rating = updated[index]
+				ai.Save(c)
+				if err := store.Ratings().Add(c, ai.Id, change); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"github.com/zond/stockholm-ai/state"
+)
+
+/*
+Replay returns a replay of all turns of self played so far.
+*/
+func (self *Game) Replay(c common.Context) *state.Replay {
+	turns := GetTurnsByParent(c, self.Id)
+	states := make([]*state.State, len(turns))
+	for index, turn := range turns {
+		states[index] = turn.State
+	}
+	result := state.NewReplay(states)
+	result.Generator = self.Generator
+	result.Map = self.Map
+	result.Players = make([]state.PlayerId, len(self.Players))
+	for index, playerId := range self.Players {
+		result.Players[index] = state.PlayerId(playerId)
+	}
+	result.PlayerNames = self.PlayerNames
+	if self.State == StateFinished {
+		result.Placements = self.Placements
+	}
+	return result
+}
+
+/*
+ImportReplay validates replay and creates a game owned by owner from it, with a turn for each state it re-simulates.
+
+Imported games are finished if the replay has placements, and aborted if not. They never change the stats of any AIs.
+*/
+func ImportReplay(c common.Context, replay *state.Replay, owner string) (result *Game, err error) {
+	states, err := replay.States(common.PlatformLogger{Context: c})
+	if err != nil {
+		return
+	}
+	if len(replay.PlayerNames) != len(replay.Players) {
+		return nil, fmt.Errorf("Replay has %v players, but %v player names", len(replay.Players), len(replay.PlayerNames))
+	}
+	now := time.Now()
+	result = &Game{
+		Players:     make([]string, len(replay.Players)),
+		PlayerNames: replay.PlayerNames,
+		State:       StateAborted,
+		Length:      len(states),
+		Seed:        replay.Seed,
+		Rules:       replay.Rules,
+		Generator:   replay.Generator,
+		Map:         replay.Map,
+		Placements:  replay.Placements,
+		Imported:    true,
+		Owner:       owner,
+		CreatedAt:   now,
+	}
+	for index, playerId := range replay.Players {
+		result.Players[index] = string(playerId)
+	}
+	result.eliminate(states[len(states)-1])
+	if len(replay.Placements) > 0 {
+		result.State = StateFinished
+		if winners := replay.Placements.Winners(); len(winners) == 1 {
+			result.Winner = string(winners[0])
+		}
+	}
+	if err = transaction(c, func(c common.Context) error {
+		if err := store.Games().Save(c, result); err != nil {
+			return err
+		}
+		for ordinal, s := range states {
+			(&Turn{
+				Ordinal:   ordinal,
+				State:     s,
+				CreatedAt: now.Add(time.Duration(ordinal) * time.Millisecond),
+			}).Save(c, result.Id)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result.process(c), nil
+}
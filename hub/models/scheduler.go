@@ -0,0 +1,254 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/taskqueue"
+)
+
+var errSchedulerStopped = fmt.Errorf("Scheduler stopped")
+
+/*
+GameScheduler runs the turns of games, one at a time per game, some time after they are scheduled.
+*/
+type GameScheduler interface {
+	// Schedule makes the next turn of a game run some time later.
+	Schedule(c common.Context, gameId string) error
+	// Stop stops running turns, and waits for the running turns to finish.
+	Stop()
+}
+
+var scheduler GameScheduler = TaskQueueScheduler{Queue: "games"}
+
+/*
+UseScheduler makes s run the turns of all games.
+*/
+func UseScheduler(s GameScheduler) {
+	scheduler = s
+}
+
+/*
+ResumeGames schedules the next turn of all unfinished games, for schedulers that forget what they scheduled when stopped.
+*/
+func ResumeGames(c common.Context) (err error) {
+	ids, err := store.Games().Unfinished(c)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		if err = scheduler.Schedule(c, id); err != nil {
+			return
+		}
+	}
+	common.Infof(c, "Resumed %v unfinished games", len(ids))
+	return
+}
+
+/*
+runScheduledTurn runs the next turn of a game, and schedules the one after that if the game goes on.
+*/
+func runScheduledTurn(c common.Context, id string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	if runTurn(c, id) {
+		err = scheduler.Schedule(c, id)
+	}
+	return
+}
+
+var nextTurnFunc = delay.Func("models/scheduler.nextTurnFunc", func(c context.Context, id string) error {
+	return runScheduledTurn(common.Context{Context: c}, id)
+})
+
+/*
+legacyNextTurnFunc runs the tasks queued before turns were scheduled by game id, which used the key of the game and the names of its players as arguments.
+
+It can be removed when no such tasks are left in the queue.
+*/
+var legacyNextTurnFunc = delay.Func("models/game.nextTurnFunc", func(c context.Context, id *datastore.Key, playerNames []string) error {
+	return runScheduledTurn(common.Context{Context: c}, id.Encode())
+})
+
+/*
+TaskQueueScheduler runs turns in the Google App Engine task queue Queue.
+
+Concurrency, retries and backoff are configured for the queue in queue.yaml, and scheduled turns survive restarts.
+*/
+type TaskQueueScheduler struct {
+	Queue string
+}
+
+func (self TaskQueueScheduler) Schedule(c common.Context, gameId string) (err error) {
+	task, err := nextTurnFunc.Task(gameId)
+	if err != nil {
+		return
+	}
+	_, err = taskqueue.Add(c, task, self.Queue)
+	return
+}
+
+func (self TaskQueueScheduler) Stop() {
+}
+
+/*
+WorkerPoolScheduler runs turns in a pool of goroutines, retrying failed turns with exponential backoff.
+
+Scheduled turns are forgotten when it stops, so use ResumeGames when starting it.
+*/
+type WorkerPoolScheduler struct {
+	// Context is the context turns run in.
+	Context context.Context
+	// MinBackoff is how long to wait before retrying a failed turn the first time. It doubles for each failure.
+	MinBackoff time.Duration
+	// MaxBackoff is the longest time to wait before retrying a failed turn.
+	MaxBackoff time.Duration
+	// MaxAttempts is how many times to try a turn before giving up on the game until the next restart.
+	MaxAttempts int
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	queue    []string
+	queued   map[string]bool
+	inFlight map[string]bool
+	attempts map[string]int
+	timers   map[string]*time.Timer
+	stopped  bool
+	running  sync.WaitGroup
+}
+
+/*
+NewWorkerPoolScheduler returns a scheduler running at most workers games at a time.
+*/
+func NewWorkerPoolScheduler(c context.Context, workers int) (result *WorkerPoolScheduler) {
+	result = &WorkerPoolScheduler{
+		Context:     c,
+		MinBackoff:  time.Second,
+		MaxBackoff:  time.Minute,
+		MaxAttempts: 10,
+		queued:      map[string]bool{},
+		inFlight:    map[string]bool{},
+		attempts:    map[string]int{},
+		timers:      map[string]*time.Timer{},
+	}
+	result.cond = sync.NewCond(&result.lock)
+	for i := 0; i < workers; i++ {
+		result.running.Add(1)
+		go result.work()
+	}
+	return
+}
+
+func (self *WorkerPoolScheduler) Schedule(c common.Context, gameId string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.stopped {
+		return errSchedulerStopped
+	}
+	self.enqueue(gameId)
+	return nil
+}
+
+/*
+enqueue queues gameId, unless it is already queued. Games that are running get queued too, but no worker picks them up until the running turn is done.
+*/
+func (self *WorkerPoolScheduler) enqueue(gameId string) {
+	if !self.queued[gameId] {
+		self.queued[gameId] = true
+		self.queue = append(self.queue, gameId)
+		self.cond.Signal()
+	}
+}
+
+func (self *WorkerPoolScheduler) next() (gameId string, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for {
+		if self.stopped {
+			return
+		}
+		for index, queued := range self.queue {
+			if !self.inFlight[queued] {
+				self.queue = append(self.queue[:index], self.queue[index+1:]...)
+				delete(self.queued, queued)
+				self.inFlight[queued] = true
+				return queued, true
+			}
+		}
+		self.cond.Wait()
+	}
+}
+
+func (self *WorkerPoolScheduler) done(gameId string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.inFlight, gameId)
+	self.cond.Broadcast()
+}
+
+func (self *WorkerPoolScheduler) work() {
+	defer self.running.Done()
+	for {
+		gameId, ok := self.next()
+		if !ok {
+			return
+		}
+		c := common.Context{Context: self.Context}
+		err := runScheduledTurn(c, gameId)
+		self.done(gameId)
+		if err != nil {
+			self.retry(c, gameId, err)
+		} else {
+			self.lock.Lock()
+			delete(self.attempts, gameId)
+			self.lock.Unlock()
+		}
+	}
+}
+
+func (self *WorkerPoolScheduler) retry(c common.Context, gameId string, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.stopped {
+		return
+	}
+	self.attempts[gameId] += 1
+	attempts := self.attempts[gameId]
+	if attempts >= self.MaxAttempts {
+		delete(self.attempts, gameId)
+		common.Errorf(c, "Giving up on %v after %v failed attempts, last one due to %v", gameId, attempts, err)
+		return
+	}
+	backoff := self.MinBackoff << uint(attempts-1)
+	if backoff > self.MaxBackoff || backoff <= 0 {
+		backoff = self.MaxBackoff
+	}
+	common.Errorf(c, "Retrying %v in %v due to %v", gameId, backoff, err)
+	self.timers[gameId] = time.AfterFunc(backoff, func() {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+		delete(self.timers, gameId)
+		if !self.stopped {
+			self.enqueue(gameId)
+		}
+	})
+}
+
+func (self *WorkerPoolScheduler) Stop() {
+	self.lock.Lock()
+	self.stopped = true
+	for _, timer := range self.timers {
+		timer.Stop()
+	}
+	self.cond.Broadcast()
+	self.lock.Unlock()
+	self.running.Wait()
+}
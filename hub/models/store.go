@@ -0,0 +1,93 @@
+package models
+
+import (
+	"github.com/zond/stockholm-ai/hub/common"
+	"golang.org/x/net/context"
+)
+
+/*
+Store is where the hub keeps its AIs, games, turns, errors, rating changes, maps and tournaments.
+
+Ids are opaque strings chosen by the Store. Get methods return nil, nil when nothing is found.
+*/
+type Store interface {
+	// Transaction runs f so that everything it does through the Store using the context it is given either happens, or doesn't.
+	Transaction(c context.Context, f func(context.Context) error) error
+	AIs() AIRepository
+	AIErrors() AIErrorRepository
+	Ratings() RatingRepository
+	Games() GameRepository
+	Turns() TurnRepository
+	Maps() MapRepository
+	Tournaments() TournamentRepository
+}
+
+type AIRepository interface {
+	Get(c context.Context, id string) (*AI, error)
+	All(c context.Context) (AIs, error)
+	// Save gives ai an Id if it doesn't have one.
+	Save(c context.Context, ai *AI) error
+	Delete(c context.Context, id string) error
+}
+
+type AIErrorRepository interface {
+	Add(c context.Context, aiId string, aiError *AIError) error
+	// Latest returns the limit latest errors of an AI, latest first.
+	Latest(c context.Context, aiId string, limit int) (AIErrors, error)
+}
+
+type RatingRepository interface {
+	Add(c context.Context, aiId string, change *RatingChange) error
+	// History returns the limit latest rating changes of an AI, latest first.
+	History(c context.Context, aiId string, limit int) (RatingChanges, error)
+}
+
+type GameRepository interface {
+	Get(c context.Context, id string) (*Game, error)
+	// Page returns limit games, latest first, skipping the offset latest ones.
+	Page(c context.Context, offset, limit int) (GamePage, error)
+	// Save gives game an Id if it doesn't have one.
+	Save(c context.Context, game *Game) error
+	// Unfinished returns the ids of all games that are created, playing or paused.
+	Unfinished(c context.Context) ([]string, error)
+}
+
+type TurnRepository interface {
+	// All returns all turns of a game, in any order.
+	All(c context.Context, gameId string) (Turns, error)
+	Get(c context.Context, gameId string, ordinal int) (*Turn, error)
+	Latest(c context.Context, gameId string) (*Turn, error)
+	Save(c context.Context, gameId string, turn *Turn) error
+}
+
+type MapRepository interface {
+	Get(c context.Context, name string) (*Map, error)
+	All(c context.Context) (Maps, error)
+	// Save replaces any map with the same name.
+	Save(c context.Context, m *Map) error
+	Delete(c context.Context, name string) error
+}
+
+type TournamentRepository interface {
+	Get(c context.Context, id string) (*Tournament, error)
+	All(c context.Context) (Tournaments, error)
+	// Save gives tournament an Id if it doesn't have one.
+	Save(c context.Context, tournament *Tournament) error
+}
+
+var store Store = DatastoreStore{}
+
+/*
+UseStore makes the hub keep everything in s instead of in the Google App Engine datastore.
+*/
+func UseStore(s Store) {
+	store = s
+}
+
+func transaction(c common.Context, f func(common.Context) error) error {
+	return store.Transaction(c, func(inner context.Context) error {
+		cpy := c
+		cpy.Context = inner
+		return f(cpy)
+	})
+}
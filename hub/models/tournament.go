@@ -0,0 +1,710 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"github.com/zond/stockholm-ai/state"
+)
+
+/*
+TournamentFormat decides how the players of a tournament are paired each round.
+*/
+type TournamentFormat string
+
+const (
+	// RoundRobin pairs every player with every other player once.
+	RoundRobin TournamentFormat = "RoundRobin"
+	// Swiss pairs players with similar standings that haven't met before, for a fixed number of rounds.
+	Swiss TournamentFormat = "Swiss"
+	// SingleElimination lets only the winner of each pairing go on to the next round.
+	SingleElimination TournamentFormat = "SingleElimination"
+)
+
+func (self TournamentFormat) Validate() error {
+	switch self {
+	case RoundRobin, Swiss, SingleElimination:
+		return nil
+	}
+	return fmt.Errorf("Format must be %#v, %#v or %#v, not %#v", RoundRobin, Swiss, SingleElimination, self)
+}
+
+type TournamentState string
+
+const (
+	TournamentPlaying  TournamentState = "Playing"
+	TournamentFinished TournamentState = "Finished"
+)
+
+/*
+Match is a pairing of two players in a round of a tournament, or a bye if it only has one player.
+*/
+type Match struct {
+	Players []string
+	// Games contains the games of this match created so far.
+	Games []string
+	// Started is the number of games created for this match, or about to be.
+	Started int
+	// Finished contains the games of this match that have finished.
+	Finished []string
+	// Aborted contains the games of this match that were aborted. They are replayed, since they don't decide anything.
+	Aborted []string
+	// Points contain the points of each player, 1 for each win and 0.5 for each draw.
+	Points []float64
+	// Wins contain the number of games each player won.
+	Wins  []int
+	Draws int
+	// Winner is the player with the most points when all games have finished, or the higher seeded one if their points are equal.
+	Winner string
+}
+
+func (self *Match) bye() bool {
+	return len(self.Players) == 1
+}
+
+/*
+missing returns the number of games that have to be created for self to get gamesPerPairing finished games, if none of them are aborted.
+*/
+func (self *Match) missing(gamesPerPairing int) int {
+	if self.bye() || self.Winner != "" {
+		return 0
+	}
+	started := self.Started
+	if len(self.Games) > started {
+		started = len(self.Games)
+	}
+	return gamesPerPairing + len(self.Aborted) - started
+}
+
+/*
+record adds the result of game, which has ended, to self. Seeds are the players of the tournament, best first, and break ties. It returns whether game was aborted and has to be replayed.
+*/
+func (self *Match) record(game *Game, gamesPerPairing int, seeds []string) (replay bool) {
+	for _, id := range append(append([]string{}, self.Finished...), self.Aborted...) {
+		if id == game.Id {
+			return false
+		}
+	}
+	found := false
+	for _, id := range self.Games {
+		found = found || id == game.Id
+	}
+	if !found {
+		self.Games = append(self.Games, game.Id)
+	}
+	if game.State != StateFinished {
+		self.Aborted = append(self.Aborted, game.Id)
+		return self.Winner == ""
+	}
+	self.Finished = append(self.Finished, game.Id)
+	if winners := game.Placements.Winners(); len(winners) == 1 {
+		for index, player := range self.Players {
+			if state.PlayerId(player) == winners[0] {
+				self.Points[index] += 1
+				self.Wins[index] += 1
+			}
+		}
+	} else {
+		for index, _ := range self.Players {
+			self.Points[index] += 0.5
+		}
+		self.Draws += 1
+	}
+	if self.Winner == "" && len(self.Finished) >= gamesPerPairing {
+		better := 0
+		if self.Points[1] > self.Points[0] || (self.Points[1] == self.Points[0] && seedOf(seeds, self.Players[1]) < seedOf(seeds, self.Players[0])) {
+			better = 1
+		}
+		self.Winner = self.Players[better]
+	}
+	return false
+}
+
+/*
+seedOf returns the position of player in seeds, or len(seeds) if it isn't there.
+*/
+func seedOf(seeds []string, player string) int {
+	for index, seed := range seeds {
+		if seed == player {
+			return index
+		}
+	}
+	return len(seeds)
+}
+
+func newMatch(players ...string) Match {
+	result := Match{
+		Players: players,
+		Points:  make([]float64, len(players)),
+		Wins:    make([]int, len(players)),
+	}
+	if result.bye() {
+		result.Winner = players[0]
+	}
+	return result
+}
+
+/*
+Round contains the matches of one round of a tournament.
+*/
+type Round []Match
+
+func (self Round) done() bool {
+	for _, match := range self {
+		if match.Winner == "" {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Standing is how well a player is doing in a tournament.
+*/
+type Standing struct {
+	Player string
+	Name   string
+	// Rank is 1 for the best players. Players with equal points, elimination round and tiebreaks share rank.
+	Rank   int
+	Points float64
+	Wins   int
+	Draws  int
+	Losses int
+	Byes   int
+	// Eliminated is the round a single elimination player lost in, or 0 if it hasn't.
+	Eliminated int
+	// Buchholz is the sum of the points of the opponents of the player, the first tiebreak.
+	Buchholz float64
+	// SonnebornBerger is the sum of the points of the opponents of the player, weighted by the points the player got against them, the second tiebreak.
+	SonnebornBerger float64
+	seed            int
+}
+
+/*
+Standings are sorted from best to worst.
+
+Players still in a single elimination tournament beat the ones who aren't, and eliminated players are ranked by how late they lost. Then players are ranked by points, Buchholz and Sonneborn-Berger.
+*/
+type Standings []Standing
+
+func (self Standings) Len() int {
+	return len(self)
+}
+
+func (self Standings) better(i, j int) bool {
+	if (self[i].Eliminated == 0) != (self[j].Eliminated == 0) {
+		return self[i].Eliminated == 0
+	}
+	if self[i].Eliminated != self[j].Eliminated {
+		return self[i].Eliminated > self[j].Eliminated
+	}
+	if self[i].Points != self[j].Points {
+		return self[i].Points > self[j].Points
+	}
+	if self[i].Buchholz != self[j].Buchholz {
+		return self[i].Buchholz > self[j].Buchholz
+	}
+	return self[i].SonnebornBerger > self[j].SonnebornBerger
+}
+
+func (self Standings) Less(i, j int) bool {
+	if self.better(i, j) {
+		return true
+	}
+	if self.better(j, i) {
+		return false
+	}
+	return self[i].seed < self[j].seed
+}
+
+func (self Standings) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+type Tournaments []Tournament
+
+func (self Tournaments) Len() int {
+	return len(self)
+}
+
+func (self Tournaments) Less(i, j int) bool {
+	return self[j].CreatedAt.Before(self[i].CreatedAt)
+}
+
+func (self Tournaments) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+func (self Tournaments) process(c common.Context) Tournaments {
+	for index, _ := range self {
+		(&self[index]).process(c)
+	}
+	return self
+}
+
+/*
+Tournament plays games between a set of AIs, round by round, pairing them according to Format.
+*/
+type Tournament struct {
+	Id     string `datastore:"-"`
+	Name   string
+	Format TournamentFormat
+	// Players are ordered by seed, best first, which is their conservative rating when the tournament was created.
+	Players     []string
+	PlayerNames []string `datastore:"-"`
+	// GamesPerPairing is the number of games each match consists of. Players take turns being first in them.
+	GamesPerPairing int
+	// Rounds is the number of rounds. It can be chosen for Swiss tournaments, and defaults to enough rounds to find a clear winner.
+	Rounds int
+	// Schedule contains the rounds paired so far.
+	Schedule  []Round   `datastore:"-"`
+	Standings Standings `datastore:"-"`
+	State     TournamentState
+	// The games of the tournament use these settings, like any other game.
+	Rules              state.Rules
+	Generator          string
+	Map                string
+	MoveDeadlineMillis int
+	LateOrders         LateOrderPolicy
+	Owner              string `json:"-"`
+	IsOwner            bool   `datastore:"-"`
+	CreatedAt          time.Time
+}
+
+/*
+Validate returns an error if self can't be played.
+*/
+func (self *Tournament) Validate() error {
+	if err := self.Format.Validate(); err != nil {
+		return err
+	}
+	if self.GamesPerPairing < 0 {
+		return fmt.Errorf("GamesPerPairing must be >= 0, not %v", self.GamesPerPairing)
+	}
+	if self.Rounds < 0 {
+		return fmt.Errorf("Rounds must be >= 0, not %v", self.Rounds)
+	}
+	if len(self.Players) < 2 {
+		return fmt.Errorf("Tournaments need at least 2 players, not %v", len(self.Players))
+	}
+	seen := map[string]bool{}
+	for _, player := range self.Players {
+		if seen[player] {
+			return fmt.Errorf("%v can only play once in a tournament", player)
+		}
+		seen[player] = true
+	}
+	return nil
+}
+
+/*
+Game returns a game between players, configured like the games of self.
+*/
+func (self *Tournament) Game(players ...string) *Game {
+	return &Game{
+		Players:            players,
+		Rules:              self.Rules,
+		Generator:          self.Generator,
+		Map:                self.Map,
+		MoveDeadlineMillis: self.MoveDeadlineMillis,
+		LateOrders:         self.LateOrders,
+		Owner:              self.Owner,
+		Tournament:         self.Id,
+	}
+}
+
+/*
+standings returns the standings of the players, best first.
+*/
+func (self *Tournament) standings() (result Standings) {
+	result = make(Standings, len(self.Players))
+	byPlayer := map[string]*Standing{}
+	for index, player := range self.Players {
+		result[index] = Standing{
+			Player: player,
+			seed:   index,
+		}
+		if index < len(self.PlayerNames) {
+			result[index].Name = self.PlayerNames[index]
+		}
+		byPlayer[player] = &result[index]
+	}
+	for roundIndex, round := range self.Schedule {
+		for _, match := range round {
+			if match.bye() {
+				byPlayer[match.Players[0]].Points += float64(self.GamesPerPairing)
+				byPlayer[match.Players[0]].Byes += 1
+				continue
+			}
+			for index, player := range match.Players {
+				standing := byPlayer[player]
+				standing.Points += match.Points[index]
+				standing.Wins += match.Wins[index]
+				standing.Draws += match.Draws
+				standing.Losses += match.Wins[1-index]
+				if self.Format == SingleElimination && match.Winner != "" && match.Winner != player {
+					standing.Eliminated = roundIndex + 1
+				}
+			}
+		}
+	}
+	for _, round := range self.Schedule {
+		for _, match := range round {
+			if match.bye() {
+				continue
+			}
+			for index, player := range match.Players {
+				opponent := byPlayer[match.Players[1-index]]
+				byPlayer[player].Buchholz += opponent.Points
+				byPlayer[player].SonnebornBerger += match.Points[index] * opponent.Points
+			}
+		}
+	}
+	sort.Sort(result)
+	for index, _ := range result {
+		if index > 0 && !result.better(index-1, index) {
+			result[index].Rank = result[index-1].Rank
+		} else {
+			result[index].Rank = index + 1
+		}
+	}
+	return
+}
+
+/*
+roundRobinRound returns round number roundIndex (starting at 0) of a round robin between players, using the circle method.
+*/
+func roundRobinRound(players []string, roundIndex int) (result Round) {
+	circle := append([]string{}, players...)
+	if len(circle)%2 == 1 {
+		circle = append(circle, "")
+	}
+	rotated := make([]string, len(circle))
+	rotated[0] = circle[0]
+	for index := 1; index < len(circle); index++ {
+		rotated[index] = circle[1+(index-1+roundIndex)%(len(circle)-1)]
+	}
+	for index := 0; index < len(rotated)/2; index++ {
+		a, b := rotated[index], rotated[len(rotated)-1-index]
+		if a == "" {
+			result = append(result, newMatch(b))
+		} else if b == "" {
+			result = append(result, newMatch(a))
+		} else {
+			result = append(result, newMatch(a, b))
+		}
+	}
+	return
+}
+
+/*
+swissRound pairs each player with the best placed player below it in the standings that it hasn't met yet, or just the best placed one if it has met them all.
+
+With an odd number of players, the worst placed player without a bye gets one.
+*/
+func (self *Tournament) swissRound() (result Round) {
+	met := map[string]bool{}
+	hadBye := map[string]bool{}
+	for _, round := range self.Schedule {
+		for _, match := range round {
+			if match.bye() {
+				hadBye[match.Players[0]] = true
+			} else {
+				met[match.Players[0]+"/"+match.Players[1]] = true
+				met[match.Players[1]+"/"+match.Players[0]] = true
+			}
+		}
+	}
+	order := []string{}
+	for _, standing := range self.standings() {
+		order = append(order, standing.Player)
+	}
+	var bye *Match
+	if len(order)%2 == 1 {
+		byeIndex := len(order) - 1
+		for index := len(order) - 1; index >= 0; index-- {
+			if !hadBye[order[index]] {
+				byeIndex = index
+				break
+			}
+		}
+		match := newMatch(order[byeIndex])
+		bye = &match
+		order = append(order[:byeIndex], order[byeIndex+1:]...)
+	}
+	paired := map[string]bool{}
+	for index, player := range order {
+		if paired[player] {
+			continue
+		}
+		opponent := ""
+		for _, candidate := range order[index+1:] {
+			if !paired[candidate] {
+				if opponent == "" {
+					opponent = candidate
+				}
+				if !met[player+"/"+candidate] {
+					opponent = candidate
+					break
+				}
+			}
+		}
+		paired[player] = true
+		paired[opponent] = true
+		result = append(result, newMatch(player, opponent))
+	}
+	if bye != nil {
+		result = append(result, *bye)
+	}
+	return
+}
+
+/*
+bracketSize returns the smallest power of two not smaller than players.
+*/
+func bracketSize(players int) (result int) {
+	result = 1
+	for result < players {
+		result *= 2
+	}
+	return
+}
+
+/*
+eliminationRound pairs the winners of the previous round, or seeds the players so that the best ones meet as late as possible in the first round.
+
+Seeds without opponents in the first round get byes.
+*/
+func (self *Tournament) eliminationRound() (result Round) {
+	if len(self.Schedule) > 0 {
+		previous := self.Schedule[len(self.Schedule)-1]
+		for index := 0; index+1 < len(previous); index += 2 {
+			result = append(result, newMatch(previous[index].Winner, previous[index+1].Winner))
+		}
+		return
+	}
+	seeds := []int{0}
+	for len(seeds) < bracketSize(len(self.Players)) {
+		next := make([]int, 0, len(seeds)*2)
+		for _, seed := range seeds {
+			next = append(next, seed, len(seeds)*2-1-seed)
+		}
+		seeds = next
+	}
+	for index := 0; index < len(seeds); index += 2 {
+		if seeds[index+1] < len(self.Players) {
+			result = append(result, newMatch(self.Players[seeds[index]], self.Players[seeds[index+1]]))
+		} else {
+			result = append(result, newMatch(self.Players[seeds[index]]))
+		}
+	}
+	return
+}
+
+/*
+advance pairs the next round if the current one is done, or finishes self if it was the last one. It returns whether it paired a new round.
+*/
+func (self *Tournament) advance() (paired bool) {
+	for self.State == TournamentPlaying && (len(self.Schedule) == 0 || self.Schedule[len(self.Schedule)-1].done()) {
+		if len(self.Schedule) >= self.Rounds {
+			self.State = TournamentFinished
+			return
+		}
+		var round Round
+		switch self.Format {
+		case RoundRobin:
+			round = roundRobinRound(self.Players, len(self.Schedule))
+		case Swiss:
+			round = self.swissRound()
+		case SingleElimination:
+			round = self.eliminationRound()
+		}
+		self.Schedule = append(self.Schedule, round)
+		paired = true
+	}
+	return
+}
+
+/*
+record adds the result of game, which has ended, to its match. It returns whether games have to be started, because that paired a new round or game has to be replayed.
+*/
+func (self *Tournament) record(game *Game) bool {
+	if game.TournamentRound < 1 || game.TournamentRound > len(self.Schedule) || game.TournamentMatch < 0 || game.TournamentMatch >= len(self.Schedule[game.TournamentRound-1]) {
+		return false
+	}
+	replay := (&self.Schedule[game.TournamentRound-1][game.TournamentMatch]).record(game, self.GamesPerPairing, self.Players)
+	return self.advance() || replay
+}
+
+/*
+startGames creates the missing games of the matches of the current round.
+
+The games to create are reserved in a transaction first, so that concurrent calls never create more games than a match needs.
+*/
+func (self *Tournament) startGames(con common.Context) {
+	if self.State != TournamentPlaying || len(self.Schedule) == 0 {
+		return
+	}
+	roundIndex := len(self.Schedule) - 1
+	for matchIndex, _ := range self.Schedule[roundIndex] {
+		var match *Match
+		first := 0
+		common.AssertOkError(transaction(con, func(c common.Context) error {
+			match = nil
+			current := getTournamentById(c, self.Id)
+			if current == nil || current.State != TournamentPlaying || len(current.Schedule) != roundIndex+1 {
+				return nil
+			}
+			currentMatch := &current.Schedule[roundIndex][matchIndex]
+			missing := currentMatch.missing(current.GamesPerPairing)
+			if missing < 1 {
+				return nil
+			}
+			first = currentMatch.Started
+			if len(currentMatch.Games) > first {
+				first = len(currentMatch.Games)
+			}
+			currentMatch.Started = first + missing
+			match = currentMatch
+			return store.Tournaments().Save(c, current)
+		}))
+		if match == nil {
+			continue
+		}
+		ids := []string{}
+		for index := first; index < match.Started; index++ {
+			game := self.Game(match.Players[index%2], match.Players[1-index%2])
+			game.TournamentRound = roundIndex + 1
+			game.TournamentMatch = matchIndex
+			ids = append(ids, game.Save(con).Id)
+		}
+		common.AssertOkError(transaction(con, func(c common.Context) error {
+			current := getTournamentById(c, self.Id)
+			if current == nil || len(current.Schedule) <= roundIndex {
+				return nil
+			}
+			currentMatch := &current.Schedule[roundIndex][matchIndex]
+			for _, id := range ids {
+				found := false
+				for _, existing := range currentMatch.Games {
+					found = found || existing == id
+				}
+				if !found {
+					currentMatch.Games = append(currentMatch.Games, id)
+				}
+			}
+			return store.Tournaments().Save(c, current)
+		}))
+	}
+}
+
+/*
+reportToTournament adds the result of the ended game self to its tournament, if any, and starts the games of the next round if that was the last game of the current one, or a replay if self was aborted.
+*/
+func (self *Game) reportToTournament(con common.Context) {
+	if self.Tournament == "" {
+		return
+	}
+	var next *Tournament
+	if err := transaction(con, func(c common.Context) error {
+		tournament := getTournamentById(c, self.Tournament)
+		if tournament == nil {
+			return nil
+		}
+		if tournament.record(self) {
+			next = tournament
+		}
+		return store.Tournaments().Save(c, tournament)
+	}); err != nil {
+		common.Errorf(con, "Got %v when trying to report %v to %v", err, self.Id, self.Tournament)
+		return
+	}
+	if next != nil {
+		next.startGames(con)
+	}
+}
+
+func (self *Tournament) process(c common.Context) *Tournament {
+	self.PlayerNames = make([]string, len(self.Players))
+	for index, id := range self.Players {
+		if ai := GetAIById(c, id); ai != nil {
+			self.PlayerNames[index] = ai.Name
+		} else {
+			self.PlayerNames[index] = "[redacted]"
+		}
+	}
+	self.Standings = self.standings()
+	self.IsOwner = c.User != nil && self.Owner == c.User.Email
+	return self
+}
+
+func getTournamentById(c common.Context, id string) *Tournament {
+	tournament, err := store.Tournaments().Get(c, id)
+	common.AssertOkError(err)
+	return tournament
+}
+
+func GetTournamentById(c common.Context, id string) (result *Tournament) {
+	result = getTournamentById(c, id)
+	if result != nil {
+		result.process(c)
+	}
+	return
+}
+
+func GetAllTournaments(c common.Context) (result Tournaments) {
+	result, err := store.Tournaments().All(c)
+	common.AssertOkError(err)
+	sort.Sort(result)
+	return result.process(c)
+}
+
+/*
+Save saves self. New tournaments get seeded and paired, and their first games are started.
+*/
+func (self *Tournament) Save(c common.Context) *Tournament {
+	if self.Id == "" {
+		ratings := map[string]float64{}
+		for _, id := range self.Players {
+			if ai := GetAIById(c, id); ai != nil {
+				ratings[id] = ai.Rating.OrDefault().Conservative()
+			}
+		}
+		sort.SliceStable(self.Players, func(i, j int) bool {
+			return ratings[self.Players[i]] > ratings[self.Players[j]]
+		})
+		if self.GamesPerPairing == 0 {
+			self.GamesPerPairing = 1
+		}
+		switch self.Format {
+		case RoundRobin:
+			self.Rounds = len(self.Players) - 1 + len(self.Players)%2
+		case SingleElimination:
+			self.Rounds = 0
+			for size := bracketSize(len(self.Players)); size > 1; size /= 2 {
+				self.Rounds += 1
+			}
+		case Swiss:
+			if self.Rounds == 0 {
+				for size := bracketSize(len(self.Players)); size > 1; size /= 2 {
+					self.Rounds += 1
+				}
+			}
+		}
+		self.CreatedAt = time.Now()
+		self.State = TournamentPlaying
+		self.Schedule = nil
+		self.Rules = self.Rules.OrDefault()
+		self.advance()
+		common.AssertOkError(store.Tournaments().Save(c, self))
+		self.startGames(c)
+		if current := getTournamentById(c, self.Id); current != nil {
+			*self = *current
+		}
+	} else {
+		common.AssertOkError(store.Tournaments().Save(c, self))
+	}
+	return self.process(c)
+}
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/zond/stockholm-ai/state"
+)
+
+func playTournamentGame(tournament *Tournament, roundIndex, matchIndex int, winner string) {
+	match := tournament.Schedule[roundIndex][matchIndex]
+	game := &Game{
+		Id:              fmt.Sprintf("%v/%v/%v", roundIndex, matchIndex, len(match.Games)),
+		State:           StateFinished,
+		TournamentRound: roundIndex + 1,
+		TournamentMatch: matchIndex,
+	}
+	for _, player := range match.Players {
+		placement := state.Placement{
+			Player: state.PlayerId(player),
+			Rank:   1,
+		}
+		if winner != "" && player != winner {
+			placement.Rank = 2
+		}
+		game.Placements = append(game.Placements, placement)
+	}
+	tournament.record(game)
+}
+
+func pairings(round Round) (result [][]string) {
+	for _, match := range round {
+		result = append(result, match.Players)
+	}
+	return
+}
+
+func TestMatchRecord(t *testing.T) {
+	match := newMatch("a", "b")
+	match.Games = []string{"g1", "g2"}
+	match.Started = 2
+	if missing := match.missing(2); missing != 0 {
+		t.Fatalf("Wanted no missing games, but got %v", missing)
+	}
+	if !match.record(&Game{Id: "g1", State: StateAborted}, 2, match.Players) {
+		t.Fatalf("Wanted aborted games to be replayed")
+	}
+	if match.record(&Game{Id: "g1", State: StateAborted}, 2, match.Players) {
+		t.Fatalf("Wanted games to only be recorded once")
+	}
+	if missing := match.missing(2); missing != 1 {
+		t.Fatalf("Wanted 1 missing game, but got %v", missing)
+	}
+	winB := state.Placements{{Player: "b", Rank: 1}, {Player: "a", Rank: 2}}
+	match.record(&Game{Id: "g2", State: StateFinished, Placements: winB}, 2, match.Players)
+	if match.Winner != "" {
+		t.Fatalf("Wanted no winner while a game was aborted, but got %v", match.Winner)
+	}
+	match.record(&Game{Id: "g3", State: StateFinished, Placements: winB}, 2, match.Players)
+	if match.Winner != "b" || !reflect.DeepEqual(match.Points, []float64{0, 2}) || !reflect.DeepEqual(match.Games, []string{"g1", "g2", "g3"}) {
+		t.Fatalf("Wanted b to win 2-0 after g3, but got %+v", match)
+	}
+	if missing := match.missing(2); missing != 0 {
+		t.Fatalf("Wanted no missing games, but got %v", missing)
+	}
+	aborted := newMatch("a", "b")
+	aborted.record(&Game{Id: "g1", State: StateAborted}, 1, aborted.Players)
+	if aborted.Winner != "" || aborted.missing(1) != 1 {
+		t.Fatalf("Wanted a match with only aborted games to be undecided and replayed, but got %+v", aborted)
+	}
+	bye := newMatch("a")
+	if missing := bye.missing(1); missing != 0 {
+		t.Fatalf("Wanted byes to have no missing games, but got %v", missing)
+	}
+}
+
+func TestRoundRobinRound(t *testing.T) {
+	for _, players := range [][]string{{"a", "b", "c", "d"}, {"a", "b", "c", "d", "e"}} {
+		met := map[string]int{}
+		byes := map[string]int{}
+		rounds := len(players) - 1 + len(players)%2
+		for roundIndex := 0; roundIndex < rounds; roundIndex++ {
+			played := map[string]bool{}
+			for _, match := range roundRobinRound(players, roundIndex) {
+				for _, player := range match.Players {
+					if played[player] {
+						t.Fatalf("Wanted %v to play once in round %v of %v, but got %+v", player, roundIndex, players, roundRobinRound(players, roundIndex))
+					}
+					played[player] = true
+				}
+				if match.bye() {
+					byes[match.Players[0]] += 1
+				} else {
+					met[match.Players[0]+"/"+match.Players[1]] += 1
+					met[match.Players[1]+"/"+match.Players[0]] += 1
+				}
+			}
+			if len(played) != len(players) {
+				t.Fatalf("Wanted all of %v in round %v, but got %+v", players, roundIndex, roundRobinRound(players, roundIndex))
+			}
+		}
+		for index, player := range players {
+			for _, opponent := range players[index+1:] {
+				if met[player+"/"+opponent] != 1 {
+					t.Fatalf("Wanted %v to meet %v once, but got %v", player, opponent, met[player+"/"+opponent])
+				}
+			}
+			if wanted := len(players) % 2; byes[player] != wanted {
+				t.Fatalf("Wanted %v to get %v byes, but got %v", player, wanted, byes[player])
+			}
+		}
+	}
+}
+
+func TestSwissRound(t *testing.T) {
+	tournament := &Tournament{
+		Format:          Swiss,
+		Players:         []string{"a", "b", "c", "d"},
+		GamesPerPairing: 1,
+		Rounds:          3,
+		State:           TournamentPlaying,
+	}
+	tournament.advance()
+	if found := pairings(tournament.Schedule[0]); !reflect.DeepEqual(found, [][]string{{"a", "b"}, {"c", "d"}}) {
+		t.Fatalf("Wanted the first round to pair by seed, but got %v", found)
+	}
+	playTournamentGame(tournament, 0, 0, "a")
+	playTournamentGame(tournament, 0, 1, "c")
+	if found := pairings(tournament.Schedule[1]); !reflect.DeepEqual(found, [][]string{{"a", "c"}, {"b", "d"}}) {
+		t.Fatalf("Wanted the winners to meet in the second round, but got %v", found)
+	}
+	playTournamentGame(tournament, 1, 0, "a")
+	playTournamentGame(tournament, 1, 1, "d")
+	if found := pairings(tournament.Schedule[2]); !reflect.DeepEqual(found, [][]string{{"a", "d"}, {"c", "b"}}) {
+		t.Fatalf("Wanted no rematches in the third round, but got %v", found)
+	}
+	odd := &Tournament{
+		Format:          Swiss,
+		Players:         []string{"a", "b", "c"},
+		GamesPerPairing: 1,
+		Rounds:          2,
+		State:           TournamentPlaying,
+	}
+	odd.advance()
+	if found := pairings(odd.Schedule[0]); !reflect.DeepEqual(found, [][]string{{"a", "b"}, {"c"}}) {
+		t.Fatalf("Wanted the worst seed to get a bye, but got %v", found)
+	}
+	playTournamentGame(odd, 0, 0, "b")
+	if found := pairings(odd.Schedule[1]); !reflect.DeepEqual(found, [][]string{{"b", "c"}, {"a"}}) {
+		t.Fatalf("Wanted the worst placed player without a bye to get one, but got %v", found)
+	}
+}
+
+func TestEliminationRound(t *testing.T) {
+	full := &Tournament{
+		Format:  SingleElimination,
+		Players: []string{"p0", "p1", "p2", "p3", "p4", "p5", "p6", "p7"},
+	}
+	if found := pairings(full.eliminationRound()); !reflect.DeepEqual(found, [][]string{{"p0", "p7"}, {"p3", "p4"}, {"p1", "p6"}, {"p2", "p5"}}) {
+		t.Fatalf("Wanted the best seeds to meet as late as possible, but got %v", found)
+	}
+	tournament := &Tournament{
+		Format:          SingleElimination,
+		Players:         []string{"p0", "p1", "p2", "p3", "p4"},
+		GamesPerPairing: 1,
+		Rounds:          3,
+		State:           TournamentPlaying,
+	}
+	tournament.advance()
+	if found := pairings(tournament.Schedule[0]); !reflect.DeepEqual(found, [][]string{{"p0"}, {"p3", "p4"}, {"p1"}, {"p2"}}) {
+		t.Fatalf("Wanted the best seeds to get byes, but got %v", found)
+	}
+	playTournamentGame(tournament, 0, 1, "p4")
+	if found := pairings(tournament.Schedule[1]); !reflect.DeepEqual(found, [][]string{{"p0", "p4"}, {"p1", "p2"}}) {
+		t.Fatalf("Wanted the winners to meet, but got %v", found)
+	}
+	playTournamentGame(tournament, 1, 0, "p0")
+	playTournamentGame(tournament, 1, 1, "p2")
+	if found := pairings(tournament.Schedule[2]); !reflect.DeepEqual(found, [][]string{{"p0", "p2"}}) {
+		t.Fatalf("Wanted a final between the winners, but got %v", found)
+	}
+	playTournamentGame(tournament, 2, 0, "p2")
+	if tournament.State != TournamentFinished {
+		t.Fatalf("Wanted the tournament to finish after the final, but got %v", tournament.State)
+	}
+	found := []string{}
+	for _, standing := range tournament.standings() {
+		found = append(found, fmt.Sprintf("%v:%v:%v", standing.Rank, standing.Player, standing.Eliminated))
+	}
+	if wanted := []string{"1:p2:0", "2:p0:3", "3:p1:2", "4:p4:2", "5:p3:1"}; !reflect.DeepEqual(found, wanted) {
+		t.Fatalf("Wanted standings %v, but got %v", wanted, found)
+	}
+	upset := &Tournament{
+		Format:          SingleElimination,
+		Players:         []string{"p0", "p1", "p2", "p3"},
+		GamesPerPairing: 1,
+		Rounds:          2,
+		State:           TournamentPlaying,
+	}
+	upset.advance()
+	playTournamentGame(upset, 0, 0, "p3")
+	playTournamentGame(upset, 0, 1, "p1")
+	if found := pairings(upset.Schedule[1]); !reflect.DeepEqual(found, [][]string{{"p3", "p1"}}) {
+		t.Fatalf("Wanted the upset winner first in the final, but got %v", found)
+	}
+	playTournamentGame(upset, 1, 0, "")
+	if winner := upset.Schedule[1][0].Winner; winner != "p1" {
+		t.Fatalf("Wanted the better seed to win a drawn final, but got %v", winner)
+	}
+}
+
+func TestStandings(t *testing.T) {
+	tournament := &Tournament{
+		Format:          RoundRobin,
+		Players:         []string{"a", "b", "c"},
+		PlayerNames:     []string{"A", "B", "C"},
+		GamesPerPairing: 1,
+		Rounds:          3,
+		State:           TournamentPlaying,
+	}
+	tournament.advance()
+	for roundIndex := 0; roundIndex < 3; roundIndex++ {
+		for matchIndex, match := range tournament.Schedule[roundIndex] {
+			if match.bye() {
+				continue
+			}
+			winner := ""
+			for _, player := range match.Players {
+				if player == "c" {
+					winner = "c"
+				}
+			}
+			playTournamentGame(tournament, roundIndex, matchIndex, winner)
+		}
+	}
+	if tournament.State != TournamentFinished {
+		t.Fatalf("Wanted the tournament to finish after all rounds, but got %v", tournament.State)
+	}
+	standings := tournament.standings()
+	wanted := Standings{
+		{Player: "c", Name: "C", Rank: 1, Points: 3, Wins: 2, Byes: 1, Buchholz: 3, SonnebornBerger: 3, seed: 2},
+		{Player: "a", Name: "A", Rank: 2, Points: 1.5, Draws: 1, Losses: 1, Byes: 1, Buchholz: 4.5, SonnebornBerger: 0.75, seed: 0},
+		{Player: "b", Name: "B", Rank: 2, Points: 1.5, Draws: 1, Losses: 1, Byes: 1, Buchholz: 4.5, SonnebornBerger: 0.75, seed: 1},
+	}
+	if !reflect.DeepEqual(standings, wanted) {
+		t.Fatalf("Wanted %+v, but got %+v", wanted, standings)
+	}
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/zond/stockholm-ai/hub/common"
+	"github.com/zond/stockholm-ai/state"
+
+	ai "github.com/zond/stockholm-ai/ai"
+	aiCommon "github.com/zond/stockholm-ai/common"
+)
+
+const (
+	// LocalScheme is the URL prefix of AIs registered in ai.DefaultRegistry, for example "local:simpleton".
+	LocalScheme = "local:"
+)
+
+/*
+Transport delivers order requests to an AI, and returns its orders.
+*/
+type Transport interface {
+	Orders(c common.Context, player *AI, req ai.OrderRequest) (state.Orders, error)
+}
+
+/*
+TransportFor returns the transport to use for player: LocalTransport if its URL starts with LocalScheme, otherwise HTTPTransport.
+*/
+func TransportFor(player *AI) Transport {
+	if strings.HasPrefix(player.URL, LocalScheme) {
+		return LocalTransport{
+			Registry: ai.DefaultRegistry,
+		}
+	}
+	return HTTPTransport{}
+}
+
+/*
+ValidateURL returns an error if url uses LocalScheme, but names an AI that isn't registered in ai.DefaultRegistry.
+*/
+func ValidateURL(url string) error {
+	if strings.HasPrefix(url, LocalScheme) {
+		if _, found := ai.DefaultRegistry.Get(strings.TrimPrefix(url, LocalScheme)); !found {
+			return fmt.Errorf("No local AI %#v, pick one of %v", url, ai.DefaultRegistry.Names())
+		}
+	}
+	return nil
+}
+
+/*
+LocalTransport calls AIs registered in Registry directly.
+*/
+type LocalTransport struct {
+	Registry *ai.Registry
+}
+
+func (self LocalTransport) Orders(c common.Context, player *AI, req ai.OrderRequest) (state.Orders, error) {
+	return self.Registry.Orders(common.PlatformLogger{Context: c}, strings.TrimPrefix(player.URL, LocalScheme), req)
+}
+
+type orderError struct {
+	Request      *http.Request
+	RequestBody  string
+	Response     *http.Response
+	ResponseBody string
+}
+
+func (self orderError) Error() string {
+	return fmt.Sprintf("Got %v from %v", self.Response.StatusCode, self.Request.URL)
+}
+
+/*
+HTTPTransport POSTs the order request as JSON to the URL of the AI.
+*/
+type HTTPTransport struct{}
+
+func (self HTTPTransport) Orders(c common.Context, player *AI, orderRequest ai.OrderRequest) (result state.Orders, err error) {
+	// encode it into a body, and remember its string representation
+	sendBody := &bytes.Buffer{}
+	aiCommon.MustEncodeJSON(sendBody, orderRequest)
+	sendBodyString := sendBody.String()
+
+	// get a client
+	client := common.HTTPClient(c)
+
+	// send the request to the ai
+	req, err := http.NewRequest("POST", player.URL, sendBody)
+	var resp *http.Response
+	if err == nil {
+		req = req.WithContext(c)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		resp, err = client.Do(req)
+	}
+
+	recvBody := &bytes.Buffer{}
+	recvBodyString := ""
+	if err == nil {
+		// check what we received
+		_, err = io.Copy(recvBody, resp.Body)
+		recvBodyString = recvBody.String()
+	}
+	// if we have no other errors, but we got a non-200
+	if err == nil && resp.StatusCode != 200 {
+		err = orderError{
+			Request:      req,
+			RequestBody:  sendBodyString,
+			Response:     resp,
+			ResponseBody: recvBodyString,
+		}
+	}
+
+	// lets try to unserialize
+	if err == nil {
+		err = json.Unmarshal(recvBody.Bytes(), &result)
+	}
+	return
+}
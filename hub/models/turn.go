@@ -7,29 +7,8 @@ import (
 
 	"github.com/zond/stockholm-ai/hub/common"
 	"github.com/zond/stockholm-ai/state"
-	"google.golang.org/appengine/datastore"
 )
 
-const (
-	TurnKind = "Turn"
-)
-
-func countTurnsKeyForParent(k interface{}) string {
-	return fmt.Sprintf("Turns{Count,Parent:%v}", k)
-}
-
-func turnsKeyForParent(k interface{}) string {
-	return fmt.Sprintf("Turns{Parent:%v}", k)
-}
-
-func givenTurnKeyForParent(k interface{}, o interface{}) string {
-	return fmt.Sprintf("Turns{Parent:%v,Ordinal:%v}", k, o)
-}
-
-func latestTurnKeyForParent(k interface{}) string {
-	return fmt.Sprintf("Turns{Latest,Parent:%v}", k)
-}
-
 type Turns []Turn
 
 func (self Turns) Len() int {
@@ -44,115 +23,121 @@ func (self Turns) Swap(i, j int) {
 	self[i], self[j] = self[j], self[i]
 }
 
+/*
+process sets the states of self, which must be consecutive and start with a keyframe, by decoding the keyframes and running Next on the state before each other turn with its orders.
+*/
 func (self Turns) process(c common.Context) Turns {
+	var previous *state.State
 	for index, _ := range self {
-		(&self[index]).process(c)
+		turn := &self[index]
+		if turn.keyframe() {
+			turn.State = &state.State{}
+			common.MustUnmarshal(turn.SerializedState, turn.State)
+			// states saved before states counted their turns
+			turn.State.Turn = turn.Ordinal
+		} else if previous != nil {
+			orders := map[state.PlayerId]state.Orders{}
+			common.MustUnmarshal(turn.SerializedOrders, &orders)
+			turn.State = previous.Clone()
+			turn.State.Next(common.PlatformLogger{Context: c}, orders)
+		}
+		previous = turn.State
 	}
 	return self
 }
 
+/*
+TurnKeyframeInterval is how often turns keep their entire state. Other turns only keep their orders, and get their states by running Next on the state of the turn before.
+*/
+const TurnKeyframeInterval = 10
+
 type Turn struct {
-	Id              *datastore.Key
-	Ordinal         int
-	SerializedState []byte       `json:"-"`
-	State           *state.State `datastore:"-"`
-	CreatedAt       time.Time
+	Ordinal int
+	// SerializedState is the state of keyframe turns.
+	SerializedState []byte `json:"-"`
+	// SerializedOrders are the orders of other turns.
+	SerializedOrders []byte       `json:"-"`
+	State            *state.State `datastore:"-"`
+	// Latencies contain how long each player of the game took to give the orders that created this turn, successfully or not, or 0 for players that weren't asked.
+	Latencies []time.Duration
+	CreatedAt time.Time
+}
+
+/*
+keyframe returns whether self keeps its entire state. Turns saved before keyframes were introduced all do.
+*/
+func (self *Turn) keyframe() bool {
+	return len(self.SerializedState) > 0
 }
 
 func (self *Turn) Next(c common.Context, orderMap map[state.PlayerId]state.Orders) (*Turn, *state.PlayerId) {
 	cpy := *self
-	cpy.Id = nil
 	cpy.Ordinal += 1
-	winner := cpy.State.Next(common.GAELogger{c}, orderMap)
+	cpy.CreatedAt = time.Time{}
+	winner := cpy.State.Next(common.PlatformLogger{Context: c}, orderMap)
 	return &cpy, winner
 }
 
-func (self *Turn) process(c common.Context) *Turn {
-	if len(self.SerializedState) > 0 {
-		self.State = &state.State{}
-		common.MustUnmarshal(self.SerializedState, self.State)
+/*
+withState returns turn with its state, by loading the turns back to the latest keyframe if turn isn't one.
+*/
+func withState(c common.Context, gameId string, turn *Turn) *Turn {
+	turns := Turns{*turn}
+	for !turns[0].keyframe() && turns[0].Ordinal > 0 {
+		previous, err := store.Turns().Get(c, gameId, turns[0].Ordinal-1)
+		common.AssertOkError(err)
+		if previous == nil {
+			panic(fmt.Errorf("No turn %v of %v to rebuild turn %v from", turns[0].Ordinal-1, gameId, turn.Ordinal))
+		}
+		turns = append(Turns{*previous}, turns...)
 	}
-	return self
+	turns.process(c)
+	return &turns[len(turns)-1]
 }
 
-func findTurnsByParent(c common.Context, parent *datastore.Key) (result Turns) {
-	ids, err := datastore.NewQuery(TurnKind).Ancestor(parent).GetAll(c, &result)
+func GetTurnsByParent(c common.Context, gameId string) (result Turns) {
+	result, err := store.Turns().All(c, gameId)
 	common.AssertOkError(err)
-	for index, id := range ids {
-		result[index].Id = id
-	}
-	if result == nil {
-		result = Turns{}
-	}
-	return
-}
-
-func GetTurnsByParent(c common.Context, parent *datastore.Key) (result Turns) {
-	common.Memoize(c, turnsKeyForParent(parent), &result, func() interface{} {
-		return findTurnsByParent(c, parent)
-	})
 	sort.Sort(result)
 	return result.process(c)
 }
 
-func findGivenTurnByParent(c common.Context, parent *datastore.Key, ordinal int) *Turn {
-	var turns Turns
-	ids, err := datastore.NewQuery(TurnKind).Ancestor(parent).Filter("Ordinal=", ordinal).Limit(1).GetAll(c, &turns)
+func GetGivenTurnByParent(c common.Context, gameId string, ordinal int) *Turn {
+	turn, err := store.Turns().Get(c, gameId, ordinal)
 	common.AssertOkError(err)
-	for index, id := range ids {
-		turns[index].Id = id
-	}
-	if len(turns) == 0 {
+	if turn == nil {
 		return nil
 	}
-	return &turns[0]
-}
-
-func GetGivenTurnByParent(c common.Context, parent *datastore.Key, ordinal int) *Turn {
-	var result Turn
-	if common.Memoize(c, givenTurnKeyForParent(parent, ordinal), &result, func() interface{} {
-		return findGivenTurnByParent(c, parent, ordinal)
-	}) {
-		return (&result).process(c)
-	}
-	return nil
+	return withState(c, gameId, turn)
 }
 
-func findLatestTurnByParent(c common.Context, parent *datastore.Key) *Turn {
-	var turns Turns
-	ids, err := datastore.NewQuery(TurnKind).Ancestor(parent).Order("-CreatedAt").Limit(1).GetAll(c, &turns)
+func GetLatestTurnByParent(c common.Context, gameId string) *Turn {
+	turn, err := store.Turns().Latest(c, gameId)
 	common.AssertOkError(err)
-	for index, id := range ids {
-		turns[index].Id = id
-	}
-	if len(turns) == 0 {
+	if turn == nil {
 		return nil
 	}
-	return &turns[0]
+	return withState(c, gameId, turn)
 }
 
-func GetLatestTurnByParent(c common.Context, parent *datastore.Key) *Turn {
-	var result Turn
-	if common.Memoize(c, latestTurnKeyForParent(parent), &result, func() interface{} {
-		return findLatestTurnByParent(c, parent)
-	}) {
-		return (&result).process(c)
+/*
+Save saves self, with its entire state if its ordinal is a multiple of TurnKeyframeInterval, and with only the orders that created its state otherwise.
+*/
+func (self *Turn) Save(c common.Context, gameId string) *Turn {
+	if self.Ordinal%TurnKeyframeInterval == 0 {
+		self.SerializedState = common.MustMarshal(self.State)
+		self.SerializedOrders = nil
+	} else {
+		orders := self.State.Orders
+		if orders == nil {
+			orders = map[state.PlayerId]state.Orders{}
+		}
+		self.SerializedState = nil
+		self.SerializedOrders = common.MustMarshal(orders)
 	}
-	return nil
-}
-
-func (self *Turn) Save(c common.Context, parent *datastore.Key) *Turn {
-	self.SerializedState = common.MustMarshal(self.State)
-	var err error
-	if self.Id == nil {
+	if self.CreatedAt.IsZero() {
 		self.CreatedAt = time.Now()
-		self.Id, err = datastore.Put(c, datastore.NewKey(c, TurnKind, "", 0, parent), self)
-		common.AssertOkError(err)
-		common.MemPut(c, latestTurnKeyForParent(parent), self)
-	} else {
-		_, err = datastore.Put(c, self.Id, self)
-		common.AssertOkError(err)
 	}
-	common.MemDel(c, turnsKeyForParent(parent), latestTurnKeyForParent(parent), givenTurnKeyForParent(parent, self.Ordinal))
+	common.AssertOkError(store.Turns().Save(c, gameId, self))
 	return self
 }
@@ -20,7 +20,7 @@ window.AIErrorsView = Backbone.View.extend({
     that.$el.html(that.template({}));
 		that.collection.each(function(err) {
 		  that.$('#accordion').append(that.collapseTemplate({
-			  title: err.get('CreatedAt') + ' ' + err.get('Error'),
+			  title: err.get('CreatedAt') + ' ' + (err.get('Type') || 'Failure') + ' after ' + Math.round((err.get('Latency') || 0) / 1000000) + 'ms: ' + err.get('Error'),
 				body: '<pre>' + err.get('ErrorDetail1') + '</pre><pre>' + err.get('ErrorDetail2') + '</pre>',				
 			}));
 		});
@@ -5,6 +5,7 @@ window.AIsView = Backbone.View.extend({
 	events: {
 	  'click .add-ai button': 'createNewAI',
 		'click .delete-button': 'deleteAI',
+		'click .ladder-button': 'toggleLadder',
 	},
 
 	initialize: function() {
@@ -23,7 +24,9 @@ window.AIsView = Backbone.View.extend({
 			Games: 0,
 			Wins: 0,
 			Losses: 0,
+			Draws: 0,
 			URL: $('.new-ai-url').val(),
+			MoveDeadlineMillis: parseInt($('.new-ai-deadline').val()) || 0,
 			IsOwner: true,
 		}, { at: 0 });
 	},
@@ -34,15 +37,30 @@ window.AIsView = Backbone.View.extend({
 		ai.destroy();
 	},
 
+	toggleLadder: function(ev) {
+	  ev.preventDefault();
+	  var ai = this.collection.get($(ev.target).attr('data-id'));
+		$.ajax({
+		  url: '/ais/' + ai.get('Id') + '/ladder',
+			type: ai.get('Ladder') && !ai.get('Suspended') ? 'DELETE' : 'POST',
+			headers: { Accept: 'application/json' },
+			success: function(data) {
+			  ai.set(data);
+				ai.trigger('sync');
+			},
+		});
+	},
+
   render: function() {
 		var that = this;
     that.$el.html(that.template({}));
 		that.collection.each(function(ai) {
-		  var tr = '<tr><td>' + ai.get('Name') + '</td><td>' + ai.get('URL') + '</td><td>' + ai.get('Games') + ' games</td><td>' + ai.get('Wins') + ' wins</td><td>' + ai.get('Losses') + ' losses</td>';
+		  var tr = '<tr><td>' + ai.get('Name') + '</td><td>' + ai.get('URL') + '</td><td>' + ai.get('Games') + ' games</td><td>' + ai.get('Wins') + ' wins</td><td>' + (ai.get('Draws') || 0) + ' draws</td><td>' + ai.get('Losses') + ' losses</td><td title="' + ai.get('Rating').Mu.toFixed(1) + ' ± ' + ai.get('Rating').Sigma.toFixed(1) + '">rating ' + ai.get('ConservativeRating').toFixed(1) + '</td><td>' + (ai.get('Suspended') ? 'suspended' : (ai.get('Ladder') ? 'ladder' : '')) + '</td>';
 		  if (ai.get('IsOwner')) {
+			  tr += '<td><button data-id="' + ai.get('Id') + '" class="btn btn-xs ladder-button">' + (ai.get('Ladder') && !ai.get('Suspended') ? 'Leave ladder' : 'Join ladder') + '</button></td>';
 			  tr += '<td><a href="/ais/' + ai.get('Id') + '/errors" class="navigate">Errors<a></td><td><button data-id="' + ai.get('Id') + '" class="btn btn-xs delete-button">Delete</button></a></td>'
 			} else {
-			  tr += '<td></td><td></td>'
+			  tr += '<td></td><td></td><td></td>'
 			}
 			that.$('table').append(tr);
 		});
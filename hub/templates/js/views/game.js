@@ -7,6 +7,7 @@ window.GameView = Backbone.View.extend({
 	  'click .turn-back-all': 'firstTurn',
 	  'click .turn-forward': 'nextTurn',
 	  'click .turn-back': 'prevTurn',
+	  'click .game-control': 'controlGame',
 	},
 
 	initialize: function(options) {
@@ -20,7 +21,7 @@ window.GameView = Backbone.View.extend({
 
 	unlessFinished: function(cb) {
 	  var that = this;
-	  if (that.model.get('State') == 'Finished') {
+	  if (that.model.get('State') == 'Finished' || that.model.get('State') == 'Aborted') {
 		  cb();
 		} else {
 			that.model.fetch({
@@ -29,6 +30,33 @@ window.GameView = Backbone.View.extend({
 		}
 	},
 
+	controlGame: function(ev) {
+		ev.preventDefault();
+		var that = this;
+		$.ajax({
+			url: '/games/' + that.model.get('Id') + '/' + $(ev.target).attr('data-action'),
+			type: 'POST',
+			headers: { Accept: 'application/json' },
+			success: function(data) {
+				that.model.set(data);
+			},
+			error: function(xhr) {
+				alert(xhr.responseText);
+			},
+		});
+	},
+
+	renderControls: function() {
+		var that = this;
+		var state = that.model.get('State');
+		that.$('.game-state').text(state);
+		that.$('.owner-controls').toggle(that.model.get('IsOwner') == true);
+		that.$('[data-action=pause]').toggle(state == 'Created' || state == 'Playing');
+		that.$('[data-action=step]').toggle(state == 'Paused');
+		that.$('[data-action=resume]').toggle(state == 'Paused');
+		that.$('[data-action=abort]').toggle(state != 'Finished' && state != 'Aborted');
+	},
+
 	firstTurn: function(ev) {
 		ev.preventDefault();
 		var that = this;
@@ -61,6 +89,35 @@ window.GameView = Backbone.View.extend({
 		});
 	},
 
+	describeEvent: function(event, players) {
+		var name = function(playerId) {
+			return players[playerId] ? players[playerId].name : playerId;
+		};
+		if (event.UnitsDeparted) {
+			var e = event.UnitsDeparted;
+			return name(e.Player) + ' sent ' + e.Units + ' units from ' + e.Src + ' to ' + e.Dst;
+		} else if (event.UnitsArrived) {
+			var e = event.UnitsArrived;
+			return e.Units + ' units of ' + name(e.Player) + ' arrived at ' + e.Dst + ' from ' + e.Src;
+		} else if (event.Battle) {
+			var e = event.Battle;
+			var losses = [];
+			for (var playerId in e.Participants) {
+				losses.push(name(playerId) + ' lost ' + (e.Losses[playerId] || 0) + ' of ' + e.Participants[playerId]);
+			}
+			return 'Battle at ' + e.Node + ': ' + losses.join(', ');
+		} else if (event.Growth) {
+			var e = event.Growth;
+			return e.Units + ' units of ' + name(e.Player) + ' grew at ' + e.Node;
+		} else if (event.Starvation) {
+			var e = event.Starvation;
+			return e.Units + ' units of ' + name(e.Player) + ' starved at ' + e.Node;
+		} else if (event.PlayerEliminated) {
+			return name(event.PlayerEliminated.Player) + ' was eliminated';
+		}
+		return '';
+	},
+
   renderTurn: function(ordinal) {
 	  var that = this;
 		that.currentTurn = ordinal;
@@ -157,6 +214,11 @@ window.GameView = Backbone.View.extend({
 					});
 					$('#' + selEscape(nodeId) + ' title').text(nodeId + '\n' + messages.join('\n'));
 				}
+				var eventList = that.$('.turn-events');
+				eventList.empty();
+				_.each(state.Events || [], function(event) {
+					eventList.append($('<li></li>').text(that.describeEvent(event, players)));
+				});
 				var parentNode = that.$('svg').parent()[0];
 				parentNode.innerHTML = parentNode.innerHTML;
 			},
@@ -189,11 +251,16 @@ window.GameView = Backbone.View.extend({
 					model: that.model,
 				}));
 				var colors = uniqueColors(playerNames.length);
+				var ranks = {};
+				_.each(that.model.get('Placements') || [], function(placement) {
+					ranks[placement.Player] = placement.Rank + '. ';
+				});
 				for (var i = 0; i < colors.length; i++) {
-					that.$('.players').append('<div style="color: ' + colors[i] + ';">' + playerNames[i] + ' </div>');
+					that.$('.players').append('<div style="color: ' + colors[i] + ';">' + (ranks[that.model.get('Players')[i]] || '') + playerNames[i] + ' </div>');
 				}
 				that.renderTurn(that.currenTurn);
 			}
+			that.renderControls();
 		}
 		return that;
 	},
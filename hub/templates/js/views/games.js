@@ -22,6 +22,17 @@ window.GamesView = Backbone.View.extend({
 		this.listenTo(this.ais, 'add', this.render);
 		this.listenTo(this.ais, 'remove', this.render);
 		this.ais.fetch({ reset: true });
+		this.generators = [];
+		var that = this;
+		$.getJSON('/generators', function(data) {
+			that.generators = data;
+			that.render();
+		});
+		this.maps = [];
+		$.getJSON('/maps', function(data) {
+			that.maps = data;
+			that.render();
+		});
 	},
 
 	firstPage: function(ev) {
@@ -45,12 +56,16 @@ window.GamesView = Backbone.View.extend({
 	createGame: function(ev) {
 		var that = this;
 	  ev.preventDefault();
-		if (that.$('select').val().length > 0) {
+		if (that.$('select.multiselect').val().length > 0) {
 			that.collection.create({
-				Players: that.$('select').val(),
+				Players: that.$('select.multiselect').val(),
+				Generator: that.$('select.generator').val(),
+				Map: that.$('select.map').val(),
+				MoveDeadlineMillis: parseInt(that.$('input.deadline').val()) || 0,
+				LateOrders: that.$('select.late-orders').val(),
 				State: 'Created',
 				Length: 0,
-				PlayerNames: _.collect(that.$('select').val(), function(id) {
+				PlayerNames: _.collect(that.$('select.multiselect').val(), function(id) {
 					return that.ais.get(id).get('Name')
 				}),
 			}, { at: 0 });
@@ -71,7 +86,13 @@ window.GamesView = Backbone.View.extend({
 			}).render().el);
 		});
 		that.ais.each(function(ai) {
-      that.$('select').append('<option value="' + ai.get('Id') + '">' + ai.get('Name') + '</option>');
+      that.$('select.multiselect').append('<option value="' + ai.get('Id') + '">' + ai.get('Name') + '</option>');
+		});
+		_.each(that.generators, function(generator) {
+			that.$('select.generator').append('<option value="' + generator + '"' + (generator == 'random' ? ' selected="selected"' : '') + '>' + generator + '</option>');
+		});
+		_.each(that.maps, function(m) {
+			that.$('select.map').append('<option value="' + m.Name + '">' + m.Name + ' (' + m.Players + ' players)</option>');
 		});
 		if (window.session.user.loggedIn()) {
 		  that.$('.add-game').show();
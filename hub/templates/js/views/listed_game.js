@@ -20,6 +20,12 @@ window.ListedGameView = Backbone.View.extend({
 			State: 'Created',
 			Length: 0,
 			PlayerNames: that.model.get('PlayerNames'),
+			Seed: that.model.get('Seed'),
+			Rules: that.model.get('Rules'),
+			Generator: that.model.get('Generator'),
+			Map: that.model.get('Map'),
+			MoveDeadlineMillis: that.model.get('MoveDeadlineMillis'),
+			LateOrders: that.model.get('LateOrders'),
 		}, { at: 0 });
 		{{end}}
 	},
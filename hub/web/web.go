@@ -2,25 +2,34 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/zond/stockholm-ai/ai"
 	"github.com/zond/stockholm-ai/hub/common"
 	"github.com/zond/stockholm-ai/hub/models"
+	"github.com/zond/stockholm-ai/rating"
+	"github.com/zond/stockholm-ai/state"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
-	"google.golang.org/appengine/user"
 
-	brokenAi "github.com/zond/stockholm-ai/broken/ai"
 	aiCommon "github.com/zond/stockholm-ai/common"
-	randomizerAi "github.com/zond/stockholm-ai/randomizer/ai"
-	simpletonAi "github.com/zond/stockholm-ai/simpleton/ai"
+
+	// register the example AIs
+	_ "github.com/zond/stockholm-ai/broken/ai"
+	_ "github.com/zond/stockholm-ai/randomizer/ai"
+	_ "github.com/zond/stockholm-ai/simpleton/ai"
 )
 
 var htmlTemplates = template.Must(template.New("htmlTemplates").ParseGlob("hub/templates/html/*.html"))
@@ -114,35 +123,45 @@ func getUser(c common.Context) {
 }
 
 func login(c common.Context) {
-	url, err := user.LoginURL(c.Context, c.Req.URL.Scheme+c.Req.URL.Host)
-	if err != nil {
+	if err := common.CurrentPlatform().Login(c, c.Resp, c.Req); err != nil {
 		panic(err)
 	}
-	c.Resp.Header().Set("Location", url)
-	c.Resp.WriteHeader(302)
 }
 
 func logout(c common.Context) {
-	url, err := user.LogoutURL(c.Context, c.Req.URL.Scheme+c.Req.URL.Host)
-	if err != nil {
+	if err := common.CurrentPlatform().Logout(c, c.Resp, c.Req); err != nil {
 		panic(err)
 	}
-	c.Resp.Header().Set("Location", url)
-	c.Resp.WriteHeader(302)
 }
 
 func getAIs(c common.Context) {
 	c.RenderJSON(models.GetAllAIs(c))
 }
 
+func getLeaderboard(c common.Context) {
+	c.RenderJSON(models.GetLeaderboard(c))
+}
+
+func getAIRatings(c common.Context) {
+	if ai := models.GetAIById(c, c.Vars["ai_id"]); ai != nil {
+		c.RenderJSON(ai.GetRatingHistory(c))
+	} else {
+		c.Resp.WriteHeader(404)
+	}
+}
+
 func getAIErrors(c common.Context) {
 	if c.Authenticated() {
-		if ai := models.GetAIById(c, common.MustDecodeKey(c.Vars["ai_id"])); ai != nil && ai.Owner == c.User.Email {
+		if ai := models.GetAIById(c, c.Vars["ai_id"]); ai != nil && ai.Owner == c.User.Email {
 			c.RenderJSON(ai.GetErrors(c))
 		}
 	}
 }
 
+func getGenerators(c common.Context) {
+	c.RenderJSON(state.MapGeneratorNames())
+}
+
 func getGames(c common.Context) {
 	limit := aiCommon.TryParseInt(c.Req.URL.Query().Get("limit"), 10)
 	offset := aiCommon.TryParseInt(c.Req.URL.Query().Get("offset"), 0)
@@ -150,38 +169,266 @@ func getGames(c common.Context) {
 }
 
 func getGame(c common.Context) {
-	c.RenderJSON(models.GetGameById(c, common.MustDecodeKey(c.Vars["game_id"])))
+	c.RenderJSON(models.GetGameById(c, c.Vars["game_id"]))
+}
+
+func getReplay(c common.Context) {
+	if game := models.GetGameById(c, c.Vars["game_id"]); game != nil {
+		c.Resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"replay-%v.json\"", game.Id))
+		c.RenderJSON(game.Replay(c))
+	} else {
+		c.Resp.WriteHeader(404)
+	}
+}
+
+func importReplay(c common.Context) {
+	if c.Authenticated() {
+		var replay state.Replay
+		aiCommon.MustDecodeJSON(c.Req.Body, &replay)
+		game, err := models.ImportReplay(c, &replay, c.User.Email)
+		if err != nil {
+			c.Resp.WriteHeader(400)
+			fmt.Fprintln(c.Resp, err)
+			return
+		}
+		c.RenderJSON(game)
+	}
+}
+
+func getFairness(c common.Context) {
+	if turn := models.GetGivenTurnByParent(c, c.Vars["game_id"], 0); turn != nil {
+		c.RenderJSON(turn.State.Fairness())
+	}
 }
 
 func getTurn(c common.Context) {
-	c.RenderJSON(models.GetGivenTurnByParent(c, common.MustDecodeKey(c.Vars["game_id"]), aiCommon.MustParseInt(c.Vars["turn_ordinal"])))
+	c.RenderJSON(models.GetGivenTurnByParent(c, c.Vars["game_id"], aiCommon.MustParseInt(c.Vars["turn_ordinal"])))
+}
+
+/*
+validateGame returns an error if the settings of game can't be played.
+*/
+func validateGame(c common.Context, game *models.Game) error {
+	if len(game.Players) < 1 {
+		return fmt.Errorf("Games need at least one player")
+	}
+	if err := game.Rules.OrDefault().Validate(); err != nil {
+		return err
+	}
+	if err := game.LateOrders.Validate(); err != nil {
+		return err
+	}
+	if game.MoveDeadlineMillis < 0 {
+		return fmt.Errorf("MoveDeadlineMillis must be >= 0, not %v", game.MoveDeadlineMillis)
+	}
+	if _, err := state.GetMapGenerator(game.Generator); err != nil {
+		return err
+	}
+	if game.Map != "" {
+		if m := models.GetMapByName(c, game.Map); m == nil {
+			return fmt.Errorf("No map named %#v", game.Map)
+		} else if m.Players < len(game.Players) {
+			return fmt.Errorf("Map %#v only has room for %v players", game.Map, m.Players)
+		}
+	}
+	return nil
 }
 
 func createGame(c common.Context) {
 	if c.Authenticated() {
 		var game models.Game
 		aiCommon.MustDecodeJSON(c.Req.Body, &game)
+		if err := validateGame(c, &game); err != nil {
+			c.Resp.WriteHeader(400)
+			fmt.Fprintln(c.Resp, err)
+			return
+		}
 		if len(game.Players) > 0 {
+			game.Id = ""
+			game.Owner = c.User.Email
+			game.Tournament = ""
+			game.Ladder = false
 			c.RenderJSON(game.Save(c))
 		}
 	}
 }
 
+func controlGame(f func(common.Context, string) (*models.Game, error)) func(common.Context) {
+	return func(c common.Context) {
+		if c.Authenticated() {
+			game := models.GetGameById(c, c.Vars["game_id"])
+			if game == nil {
+				c.Resp.WriteHeader(404)
+				return
+			}
+			if !game.IsOwner && !c.User.Admin {
+				c.Resp.WriteHeader(403)
+				fmt.Fprintln(c.Resp, "Only the creator of a game can control it")
+				return
+			}
+			result, err := f(c, game.Id)
+			if err != nil {
+				c.Resp.WriteHeader(409)
+				fmt.Fprintln(c.Resp, err)
+				return
+			}
+			c.RenderJSON(result)
+		}
+	}
+}
+
+func getTournaments(c common.Context) {
+	c.RenderJSON(models.GetAllTournaments(c))
+}
+
+func getTournament(c common.Context) {
+	if tournament := models.GetTournamentById(c, c.Vars["tournament_id"]); tournament != nil {
+		c.RenderJSON(tournament)
+	} else {
+		c.Resp.WriteHeader(404)
+	}
+}
+
+func createTournament(c common.Context) {
+	if c.Authenticated() {
+		var tournament models.Tournament
+		aiCommon.MustDecodeJSON(c.Req.Body, &tournament)
+		if err := tournament.Validate(); err != nil {
+			c.Resp.WriteHeader(400)
+			fmt.Fprintln(c.Resp, err)
+			return
+		}
+		if err := validateGame(c, tournament.Game("", "")); err != nil {
+			c.Resp.WriteHeader(400)
+			fmt.Fprintln(c.Resp, err)
+			return
+		}
+		for _, id := range tournament.Players {
+			if models.GetAIById(c, id) == nil {
+				c.Resp.WriteHeader(400)
+				fmt.Fprintf(c.Resp, "No AI %v\n", id)
+				return
+			}
+		}
+		tournament.Id = ""
+		tournament.Owner = c.User.Email
+		c.RenderJSON(tournament.Save(c))
+	}
+}
+
+func getMaps(c common.Context) {
+	c.RenderJSON(models.GetAllMaps(c))
+}
+
+func getMap(c common.Context) {
+	if m := models.GetMapByName(c, c.Vars["map_name"]); m != nil {
+		c.SetContentType("application/json; charset=UTF-8", false)
+		if err := state.SaveMap(c.Resp, m.Map); err != nil {
+			panic(err)
+		}
+	} else {
+		c.Resp.WriteHeader(404)
+	}
+}
+
+func createMap(c common.Context) {
+	if c.Authenticated() {
+		loaded, err := state.LoadMap(c.Req.Body)
+		if err != nil {
+			c.Resp.WriteHeader(400)
+			fmt.Fprintln(c.Resp, err)
+			return
+		}
+		m := &models.Map{
+			Map:   loaded,
+			Owner: c.User.Email,
+		}
+		if existing := models.GetMapByName(c, loaded.Name); existing != nil {
+			if existing.Owner != c.User.Email {
+				c.Resp.WriteHeader(403)
+				fmt.Fprintf(c.Resp, "Map %#v belongs to someone else\n", loaded.Name)
+				return
+			}
+			m.CreatedAt = existing.CreatedAt
+		}
+		c.RenderJSON(m.Save(c))
+	}
+}
+
+func deleteMap(c common.Context) {
+	if c.Authenticated() {
+		if m := models.GetMapByName(c, c.Vars["map_name"]); m != nil && m.Owner == c.User.Email {
+			m.Delete(c)
+		}
+	}
+}
+
 func createAI(c common.Context) {
 	if c.Authenticated() {
 		var ai models.AI
 		aiCommon.MustDecodeJSON(c.Req.Body, &ai)
+		if err := models.ValidateURL(ai.URL); err != nil {
+			c.Resp.WriteHeader(400)
+			fmt.Fprintln(c.Resp, err)
+			return
+		}
+		if ai.MoveDeadlineMillis < 0 {
+			c.Resp.WriteHeader(400)
+			fmt.Fprintf(c.Resp, "MoveDeadlineMillis must be >= 0, not %v\n", ai.MoveDeadlineMillis)
+			return
+		}
 		if ai.Name != "" && ai.URL != "" {
 			ai.Owner = c.User.Email
-			ai.Id = nil
+			ai.Id = ""
+			ai.Rating = rating.Default()
+			ai.Suspended = false
+			ai.ErrorRate = 0
+			ai.LastLadderGame = time.Time{}
 			c.RenderJSON(ai.Save(c))
 		}
 	}
 }
 
+func setLadder(ladder bool) func(common.Context) {
+	return func(c common.Context) {
+		if c.Authenticated() {
+			if ai := models.GetAIById(c, c.Vars["ai_id"]); ai == nil {
+				c.Resp.WriteHeader(404)
+			} else if ai.Owner != c.User.Email {
+				c.Resp.WriteHeader(403)
+				fmt.Fprintln(c.Resp, "Only the creator of an AI can enroll it in the ladder")
+			} else {
+				c.RenderJSON(models.SetLadder(c, ai.Id, ladder))
+			}
+		}
+	}
+}
+
+/*
+matchLadder lets the Google App Engine cron service, or admins, create a ladder game.
+*/
+func matchLadder(c common.Context) {
+	_, onGAE := common.CurrentPlatform().(common.GAEPlatform)
+	if !onGAE || c.Req.Header.Get("X-Appengine-Cron") != "true" {
+		if !c.Authenticated() {
+			return
+		}
+		if !c.User.Admin {
+			c.Resp.WriteHeader(403)
+			fmt.Fprintln(c.Resp, "Only admins can match the ladder")
+			return
+		}
+	}
+	game, err := models.MatchLadder(c, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if err != nil {
+		panic(err)
+	}
+	c.RenderJSON(game)
+}
+
 func deleteAI(c common.Context) {
 	if c.Authenticated() {
-		if ai := models.GetAIById(c, common.MustDecodeKey(c.Vars["ai_id"])); ai != nil && ai.Owner == c.User.Email {
+		if ai := models.GetAIById(c, c.Vars["ai_id"]); ai != nil && ai.Owner == c.User.Email {
 			ai.Delete(c)
 		}
 	}
@@ -189,14 +436,15 @@ func deleteAI(c common.Context) {
 
 func handler(f func(c common.Context)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		platform := common.CurrentPlatform()
 		c := common.Context{
-			Context: appengine.NewContext(r),
+			Context: platform.NewContext(r),
 			Req:     r,
 			Resp:    w,
 			Vars:    mux.Vars(r),
 		}
-		c.User = user.Current(c)
-		c.Version = appengine.VersionID(c.Context)
+		c.User = platform.CurrentUser(c, r)
+		c.Version = platform.VersionID(c)
 		f(c)
 	}
 }
@@ -242,7 +490,16 @@ func handleStatic(router *mux.Router, dir string) {
 	}
 }
 
+var dbFile = flag.String("db", "", "Run as an ordinary HTTP server keeping everything in this BoltDB file, instead of on Google App Engine.")
+var addr = flag.String("addr", ":8080", "Address to listen to when running as an ordinary HTTP server.")
+var usersFile = flag.String("users", "", "File with lines like email:sha256 of password in hex[:admin], allowed to log in when running as an ordinary HTTP server.")
+var dev = flag.Bool("dev", false, "Don't cache static content when running as an ordinary HTTP server.")
+var workers = flag.Int("workers", 4, "Number of games to run at the same time when running as an ordinary HTTP server.")
+var ladder = flag.Duration("ladder", 0, "How often to create a ladder game when running as an ordinary HTTP server, or 0 to not create any. On Google App Engine, cron.yaml decides.")
+
 func main() {
+	flag.Parse()
+
 	router := mux.NewRouter()
 	router.Path("/js/{ver}/all.js").HandlerFunc(handler(allJS))
 	router.Path("/css/{ver}/all.css").HandlerFunc(handler(allCSS))
@@ -251,6 +508,9 @@ func main() {
 	router.Path("/login").MatcherFunc(wantsHTML).HandlerFunc(handler(login))
 	router.Path("/logout").MatcherFunc(wantsHTML).HandlerFunc(handler(logout))
 
+	// replays are downloaded by plain links, so they don't need to ask for JSON
+	router.Path("/games/{game_id}/replay").Methods("GET").HandlerFunc(handler(getReplay))
+
 	gamesRouter := router.PathPrefix("/games").MatcherFunc(wantsJSON).Subrouter()
 
 	gameRouter := gamesRouter.PathPrefix("/{game_id}").Subrouter()
@@ -259,30 +519,116 @@ func main() {
 	turnRouter := turnsRouter.PathPrefix("/{turn_ordinal}").Subrouter()
 	turnRouter.Methods("GET").HandlerFunc(handler(getTurn))
 
+	gameRouter.Path("/fairness").Methods("GET").HandlerFunc(handler(getFairness))
+	gameRouter.Path("/pause").Methods("POST").HandlerFunc(handler(controlGame(models.PauseGame)))
+	gameRouter.Path("/resume").Methods("POST").HandlerFunc(handler(controlGame(models.ResumeGame)))
+	gameRouter.Path("/step").Methods("POST").HandlerFunc(handler(controlGame(models.StepGame)))
+	gameRouter.Path("/abort").Methods("POST").HandlerFunc(handler(controlGame(models.AbortGame)))
+
 	gameRouter.Methods("GET").HandlerFunc(handler(getGame))
 
 	gamesRouter.Methods("GET").HandlerFunc(handler(getGames))
 	gamesRouter.Methods("POST").HandlerFunc(handler(createGame))
 
+	router.Path("/replays").MatcherFunc(wantsJSON).Methods("POST").HandlerFunc(handler(importReplay))
+
+	tournamentsRouter := router.PathPrefix("/tournaments").MatcherFunc(wantsJSON).Subrouter()
+
+	tournamentsRouter.Path("/{tournament_id}").Methods("GET").HandlerFunc(handler(getTournament))
+
+	tournamentsRouter.Methods("GET").HandlerFunc(handler(getTournaments))
+	tournamentsRouter.Methods("POST").HandlerFunc(handler(createTournament))
+
+	router.Path("/generators").MatcherFunc(wantsJSON).Methods("GET").HandlerFunc(handler(getGenerators))
+
+	mapsRouter := router.PathPrefix("/maps").MatcherFunc(wantsJSON).Subrouter()
+
+	mapRouter := mapsRouter.PathPrefix("/{map_name}").Subrouter()
+	mapRouter.Methods("GET").HandlerFunc(handler(getMap))
+	mapRouter.Methods("DELETE").HandlerFunc(handler(deleteMap))
+
+	mapsRouter.Methods("GET").HandlerFunc(handler(getMaps))
+	mapsRouter.Methods("POST").HandlerFunc(handler(createMap))
+
 	aisRouter := router.PathPrefix("/ais").MatcherFunc(wantsJSON).Subrouter()
 
+	aisRouter.Path("/leaderboard").Methods("GET").HandlerFunc(handler(getLeaderboard))
+
 	aiRouter := aisRouter.PathPrefix("/{ai_id}").Subrouter()
 
 	aiErrorsRouter := aiRouter.Path("/errors").Subrouter()
 	aiErrorsRouter.Methods("GET").HandlerFunc(handler(getAIErrors))
 
+	aiRouter.Path("/ratings").Methods("GET").HandlerFunc(handler(getAIRatings))
+
+	aiLadderRouter := aiRouter.Path("/ladder").Subrouter()
+	aiLadderRouter.Methods("POST").HandlerFunc(handler(setLadder(true)))
+	aiLadderRouter.Methods("DELETE").HandlerFunc(handler(setLadder(false)))
+
 	aiRouter.Methods("DELETE").HandlerFunc(handler(deleteAI))
 
 	aisRouter.Methods("GET").HandlerFunc(handler(getAIs))
 	aisRouter.Methods("POST").HandlerFunc(handler(createAI))
 
-	router.Path("/examples/randomizer").Methods("POST").Handler(ai.HTTPHandlerFunc(common.GAELoggerFactory, randomizerAi.Randomizer{}))
-	router.Path("/examples/simpleton").Methods("POST").Handler(ai.HTTPHandlerFunc(common.GAELoggerFactory, simpletonAi.Simpleton{}))
-	router.Path("/examples/broken").Methods("POST").Handler(ai.HTTPHandlerFunc(common.GAELoggerFactory, brokenAi.Broken{}))
+	router.Path("/ladder/match").Methods("GET", "POST").HandlerFunc(handler(matchLadder))
+
+	for _, name := range ai.DefaultRegistry.Names() {
+		example, _ := ai.DefaultRegistry.Get(name)
+		router.Path("/examples/" + name).Methods("POST").Handler(ai.HTTPHandlerFunc(common.PlatformLoggerFactory, example))
+	}
 
 	handleStatic(router, "hub/static")
 
 	router.PathPrefix("/").MatcherFunc(wantsHTML).HandlerFunc(handler(index))
 	http.Handle("/", router)
-	appengine.Main()
+
+	if *dbFile == "" {
+		appengine.Main()
+		return
+	}
+
+	platform, err := common.NewSelfHostedPlatform(*usersFile)
+	if err != nil {
+		panic(err)
+	}
+	platform.Dev = *dev
+	common.SetPlatform(platform)
+	store, err := models.NewBoltStore(*dbFile)
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close()
+	models.UseStore(store)
+	scheduler := models.NewWorkerPoolScheduler(context.Background(), *workers)
+	models.UseScheduler(scheduler)
+	if err := models.ResumeGames(common.Context{Context: context.Background()}); err != nil {
+		panic(err)
+	}
+
+	var matchmaker *models.Matchmaker
+	if *ladder > 0 {
+		matchmaker = models.NewMatchmaker(context.Background(), *ladder)
+	}
+
+	server := &http.Server{
+		Addr: *addr,
+	}
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		platform.Logger.Printf("Shutting down")
+		server.Shutdown(context.Background())
+		if matchmaker != nil {
+			matchmaker.Stop()
+		}
+		scheduler.Stop()
+		close(stopped)
+	}()
+	platform.Logger.Printf("Listening to %v", *addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		panic(err)
+	}
+	<-stopped
 }
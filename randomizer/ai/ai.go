@@ -11,6 +11,7 @@ import (
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
+	ai.Register("randomizer", Randomizer{})
 }
 
 /*
@@ -0,0 +1,95 @@
+/*
+Package rating estimates the skill of players from the placements of the games they play.
+
+It uses the Bradley-Terry full pairing model of Weng and Lin, "A Bayesian Approximation Method for Online Ranking" (2011), which like TrueSkill keeps a mean and an uncertainty per player, and handles games with any number of players and ties.
+*/
+package rating
+
+import (
+	"math"
+)
+
+const (
+	// DefaultMu is the mean skill of new players.
+	DefaultMu = 25.0
+	// DefaultSigma is the uncertainty about the skill of new players.
+	DefaultSigma = DefaultMu / 3
+	// Beta is the uncertainty about how well a player performs in a single game.
+	Beta = DefaultSigma / 2
+	// Kappa keeps uncertainty from ever reaching zero.
+	Kappa = 0.0001
+)
+
+/*
+Rating is an estimate of the skill of a player.
+*/
+type Rating struct {
+	// Mu is the mean of the estimate.
+	Mu float64
+	// Sigma is the standard deviation of the estimate.
+	Sigma float64
+}
+
+/*
+Default returns the rating of players that haven't played yet.
+*/
+func Default() Rating {
+	return Rating{
+		Mu:    DefaultMu,
+		Sigma: DefaultSigma,
+	}
+}
+
+/*
+OrDefault returns self, or the default rating if self is the zero value.
+*/
+func (self Rating) OrDefault() Rating {
+	if self.Sigma == 0 {
+		return Default()
+	}
+	return self
+}
+
+/*
+Conservative returns a skill the player very likely has at least, useful for ranking players without penalizing them for having played few games.
+*/
+func (self Rating) Conservative() float64 {
+	return self.Mu - 3*self.Sigma
+}
+
+/*
+Update returns the new ratings of players with the given ratings, after they placed with the given ranks in a game.
+
+Lower ranks are better, and equal ranks are ties.
+*/
+func Update(ratings []Rating, ranks []int) (result []Rating) {
+	result = make([]Rating, len(ratings))
+	for i, _ := range ratings {
+		ri := ratings[i].OrDefault()
+		sigmaSq := ri.Sigma * ri.Sigma
+		omega := 0.0
+		delta := 0.0
+		for q, _ := range ratings {
+			if q == i {
+				continue
+			}
+			rq := ratings[q].OrDefault()
+			c := math.Sqrt(sigmaSq + rq.Sigma*rq.Sigma + 2*Beta*Beta)
+			piq := 1 / (1 + math.Exp((rq.Mu-ri.Mu)/c))
+			score := 0.5
+			if ranks[i] < ranks[q] {
+				score = 1
+			} else if ranks[i] > ranks[q] {
+				score = 0
+			}
+			gamma := ri.Sigma / c
+			omega += sigmaSq / c * (score - piq)
+			delta += gamma * sigmaSq / (c * c) * piq * (1 - piq)
+		}
+		result[i] = Rating{
+			Mu:    ri.Mu + omega,
+			Sigma: ri.Sigma * math.Sqrt(math.Max(1-delta, Kappa)),
+		}
+	}
+	return
+}
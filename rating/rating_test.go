@@ -0,0 +1,32 @@
+package rating
+
+import (
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	found := Update([]Rating{Rating{}, Default(), Default()}, []int{1, 2, 2})
+	if found[0].Mu <= DefaultMu {
+		t.Fatalf("Wanted the winner to gain, but got %+v", found[0])
+	}
+	if found[1] != found[2] {
+		t.Fatalf("Wanted tied players to get the same rating, but got %+v and %+v", found[1], found[2])
+	}
+	if found[1].Mu >= DefaultMu {
+		t.Fatalf("Wanted the losers to lose, but got %+v", found[1])
+	}
+	for _, rating := range found {
+		if rating.Sigma >= DefaultSigma {
+			t.Fatalf("Wanted uncertainty to shrink, but got %+v", rating)
+		}
+	}
+	tied := Update([]Rating{Default(), Default()}, []int{1, 1})
+	if tied[0].Mu != DefaultMu || tied[1].Mu != DefaultMu {
+		t.Fatalf("Wanted a draw between equals to keep their means, but got %+v", tied)
+	}
+	upset := Update([]Rating{Rating{Mu: 20, Sigma: 2}, Rating{Mu: 30, Sigma: 2}}, []int{1, 2})
+	expected := Update([]Rating{Rating{Mu: 30, Sigma: 2}, Rating{Mu: 20, Sigma: 2}}, []int{1, 2})
+	if upset[0].Mu-20 <= expected[0].Mu-30 {
+		t.Fatalf("Wanted an upset to move ratings more than an expected result, but got %+v and %+v", upset, expected)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
+	ai.Register("simpleton", Simpleton{})
 }
 
 /*
@@ -18,17 +19,19 @@ Simpleton will just try to colonize as much as possible by always sending half t
 type Simpleton struct{}
 
 /*
-nearestEmpty returns the path to the nearest node to src in s that has no units belonging to me.
+nearestEmpty returns the node to send units from src to in s, to get them to the nearest node that has no units belonging to me.
 */
-func (self Simpleton) nearestEmpty(me state.PlayerId, src state.NodeId, s *state.State) (result []state.NodeId) {
+func (self Simpleton) nearestEmpty(me state.PlayerId, src state.NodeId, s *state.State) (result state.NodeId) {
+	paths := s.ShortestPaths(src, nil)
+	best := -1.0
 	// For each node in s
-	for _, node := range s.Nodes {
-		// If I have no units
-		if node.Units[me] == 0 {
-			// Calculate the path to the node, and if we have no result or this result is better
-			if thisDist := s.Path(src, node.Id, nil); result == nil || len(thisDist) < len(result) {
-				// Set the result to the path
-				result = thisDist
+	for _, nodeId := range s.NodeIds() {
+		// If I have no units, and it is closer than the best so far
+		if s.Nodes[nodeId].Units[me] == 0 {
+			if route, found := paths.Route(nodeId); found && (best < 0 || route.Cost < best) {
+				// Go there
+				result = route.Next()
+				best = route.Cost
 			}
 		}
 	}
@@ -47,11 +50,11 @@ func (self Simpleton) Orders(logger common.Logger, req ai.OrderRequest) (result
 		// If me has more than 2 units there
 		if units := node.Units[me]; units > 2 {
 			// If there is a path to a another node (the nearest one) without units belonging to me
-			if nearestEmpty := self.nearestEmpty(me, node.Id, s); len(nearestEmpty) > 0 {
+			if nearestEmpty := self.nearestEmpty(me, node.Id, s); nearestEmpty != "" {
 				// Add an order moving half the units along the path to the other node
 				result = append(result, state.Order{
 					Src:   node.Id,
-					Dst:   nearestEmpty[0],
+					Dst:   nearestEmpty,
 					Units: units / 2,
 				})
 			}
@@ -0,0 +1,124 @@
+package state
+
+/*
+UnitsDeparted happens when units leave a node because of an order.
+*/
+type UnitsDeparted struct {
+	// Src is the node the units left.
+	Src NodeId
+	// Dst is the node the units are heading for.
+	Dst NodeId
+	// Player is the owner of the units.
+	Player PlayerId
+	// Units is the number of units that left.
+	Units int
+}
+
+/*
+UnitsArrived happens when units in transit reach the end of an edge.
+*/
+type UnitsArrived struct {
+	// Src is the node the units came from.
+	Src NodeId
+	// Dst is the node the units arrived at.
+	Dst NodeId
+	// Player is the owner of the units.
+	Player PlayerId
+	// Units is the number of units that arrived.
+	Units int
+}
+
+/*
+Battle happens when units of more than one player occupy the same node.
+*/
+type Battle struct {
+	// Node is where the battle took place.
+	Node NodeId
+	// Participants are the units each player had at the node before the battle.
+	Participants map[PlayerId]int
+	// Losses are the units each player lost in the battle.
+	Losses map[PlayerId]int
+}
+
+/*
+Growth happens when the units of a single player procreate on a node with room to spare.
+*/
+type Growth struct {
+	// Node is where the units grew.
+	Node NodeId
+	// Player is the owner of the units.
+	Player PlayerId
+	// Units is the number of new units.
+	Units int
+}
+
+/*
+Starvation happens when a node has more units than its size.
+*/
+type Starvation struct {
+	// Node is where the units starved.
+	Node NodeId
+	// Player is the owner of the units.
+	Player PlayerId
+	// Units is the number of units that starved.
+	Units int
+}
+
+/*
+PlayerEliminated happens when a player loses its last units, on nodes and in transit, or resigns.
+*/
+type PlayerEliminated struct {
+	// Player is the eliminated player.
+	Player PlayerId
+	// Resigned is whether the player gave up using a Resign order.
+	Resigned bool `json:",omitempty"`
+}
+
+/*
+Event is something that happened during a turn. Exactly one of its fields is set.
+*/
+type Event struct {
+	UnitsDeparted    *UnitsDeparted    `json:",omitempty"`
+	UnitsArrived     *UnitsArrived     `json:",omitempty"`
+	Battle           *Battle           `json:",omitempty"`
+	Growth           *Growth           `json:",omitempty"`
+	Starvation       *Starvation       `json:",omitempty"`
+	PlayerEliminated *PlayerEliminated `json:",omitempty"`
+}
+
+/*
+Node returns the node where the event happened, which is the source for departures and the destination for arrivals, or "" for events that aren't tied to any node.
+*/
+func (self Event) Node() NodeId {
+	switch {
+	case self.UnitsDeparted != nil:
+		return self.UnitsDeparted.Src
+	case self.UnitsArrived != nil:
+		return self.UnitsArrived.Dst
+	case self.Battle != nil:
+		return self.Battle.Node
+	case self.Growth != nil:
+		return self.Growth.Node
+	case self.Starvation != nil:
+		return self.Starvation.Node
+	}
+	return ""
+}
+
+/*
+Events are the events of a turn, in the order they happened.
+*/
+type Events []Event
+
+/*
+Eliminated returns the players eliminated by the events.
+*/
+func (self Events) Eliminated() (result PlayerIds) {
+	result = PlayerIds{}
+	for _, event := range self {
+		if event.PlayerEliminated != nil {
+			result = append(result, event.PlayerEliminated.Player)
+		}
+	}
+	return
+}
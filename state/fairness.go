@@ -0,0 +1,138 @@
+package state
+
+import (
+	"fmt"
+)
+
+/*
+VerifyAutomorphism returns an error unless mapping is a bijection from the nodes of self onto themselves that preserves node sizes, edges and edge lengths.
+*/
+func (self *State) VerifyAutomorphism(mapping map[NodeId]NodeId) error {
+	if len(mapping) != len(self.Nodes) {
+		return fmt.Errorf("Mapping covers %v nodes, but there are %v", len(mapping), len(self.Nodes))
+	}
+	images := map[NodeId]bool{}
+	for nodeId, node := range self.Nodes {
+		image, found := self.Nodes[mapping[nodeId]]
+		if !found {
+			return fmt.Errorf("%v is mapped to %v, which doesn't exist", nodeId, mapping[nodeId])
+		}
+		if images[image.Id] {
+			return fmt.Errorf("%v is the image of more than one node", image.Id)
+		}
+		images[image.Id] = true
+		if node.Size != image.Size {
+			return fmt.Errorf("%v has size %v, but its image %v has size %v", nodeId, node.Size, image.Id, image.Size)
+		}
+		if len(node.Edges) != len(image.Edges) {
+			return fmt.Errorf("%v has %v edges, but its image %v has %v", nodeId, len(node.Edges), image.Id, len(image.Edges))
+		}
+		for dst, edge := range node.Edges {
+			imageEdge, found := image.Edges[mapping[dst]]
+			if !found {
+				return fmt.Errorf("%v has an edge to %v, but its image %v has no edge to %v", nodeId, dst, image.Id, mapping[dst])
+			}
+			if len(edge.Units) != len(imageEdge.Units) {
+				return fmt.Errorf("The edge from %v to %v has length %v, but its image has length %v", nodeId, dst, len(edge.Units), len(imageEdge.Units))
+			}
+		}
+	}
+	return nil
+}
+
+/*
+PlayerFairness describes how good the start position of a player is.
+*/
+type PlayerFairness struct {
+	// Start is the node the player started at.
+	Start NodeId
+	// NodeDistance is the sum of the path lengths from the start to every node it can reach.
+	NodeDistance int
+	// Unreachable is the number of nodes that can't be reached from the start.
+	Unreachable int
+	// StartDistance is the sum of the path lengths from the start to the starts of the other players it can reach.
+	StartDistance int
+	// Territory is the number of nodes closer to this start than to any other start. Nodes a start can't reach aren't close to it at all.
+	Territory int
+	// TerritorySize is the sum of the sizes of the nodes closer to this start than to any other start.
+	TerritorySize int
+}
+
+/*
+FairnessReport describes how good the start position of each player is.
+*/
+type FairnessReport map[PlayerId]PlayerFairness
+
+/*
+Fair returns whether all players have identical numbers in the report.
+*/
+func (self FairnessReport) Fair() bool {
+	var first *PlayerFairness
+	for _, fairness := range self {
+		fairness.Start = ""
+		if first == nil {
+			cpy := fairness
+			first = &cpy
+		} else if fairness != *first {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Fairness returns a report of how good the start position of each player in Starts is, measured by path lengths from State.Path.
+
+Nodes that can't be reached from a start don't count towards its distances or territory, only towards its Unreachable nodes.
+*/
+func (self *State) Fairness() (result FairnessReport) {
+	result = FairnessReport{}
+	distances := map[PlayerId]map[NodeId]int{}
+	for playerId, start := range self.Starts {
+		distances[playerId] = map[NodeId]int{}
+		fairness := PlayerFairness{
+			Start: start,
+		}
+		for nodeId, _ := range self.Nodes {
+			path := self.Path(start, nodeId, nil)
+			if path == nil && nodeId != start {
+				fairness.Unreachable += 1
+				continue
+			}
+			distances[playerId][nodeId] = len(path)
+			fairness.NodeDistance += len(path)
+		}
+		result[playerId] = fairness
+	}
+	for playerId, fairness := range result {
+		for otherId, otherStart := range self.Starts {
+			if otherId != playerId {
+				fairness.StartDistance += distances[playerId][otherStart]
+			}
+		}
+		result[playerId] = fairness
+	}
+	for nodeId, node := range self.Nodes {
+		var closest PlayerId
+		closestDist := -1
+		tied := false
+		for playerId, _ := range self.Starts {
+			dist, found := distances[playerId][nodeId]
+			if !found {
+				continue
+			}
+			if closestDist == -1 || dist < closestDist {
+				closest, closestDist, tied = playerId, dist, false
+			} else if dist == closestDist {
+				tied = true
+			}
+		}
+		if closestDist != -1 && !tied {
+			fairness := result[closest]
+			fairness.Territory += 1
+			fairness.TerritorySize += node.Size
+			result[closest] = fairness
+		}
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package state
+
+import (
+	"io/ioutil"
+	"log"
+)
+
+var discardLogger = log.New(ioutil.Discard, "", 0)
+
+/*
+Projection is what a state is expected to look like after some turn in the future.
+*/
+type Projection struct {
+	// Units are the units each player is expected to have at each node after the turn. Players without units are left out.
+	Units map[NodeId]map[PlayerId]int
+	// Arrivals are the units each player is expected to have arrive at each node during the turn, before growth and conflicts.
+	Arrivals map[NodeId]map[PlayerId]int
+}
+
+/*
+Forecast contains the projections of a state for a number of turns, the first one being the next turn.
+*/
+type Forecast []Projection
+
+/*
+Units returns the units each player is expected to have at nodeId after turns turns, or nil if turns is outside the forecast.
+*/
+func (self Forecast) Units(nodeId NodeId, turns int) map[PlayerId]int {
+	if turns < 1 || turns > len(self) {
+		return nil
+	}
+	return self[turns-1].Units[nodeId]
+}
+
+/*
+EnemyArrivals returns the number of units not belonging to me expected to arrive at nodeId within the next turns turns.
+*/
+func (self Forecast) EnemyArrivals(me PlayerId, nodeId NodeId, turns int) (result int) {
+	for index, projection := range self {
+		if index >= turns {
+			break
+		}
+		for playerId, units := range projection.Arrivals[nodeId] {
+			if playerId != me {
+				result += units
+			}
+		}
+	}
+	return
+}
+
+/*
+Forecast projects self horizon turns into the future, assuming no new orders are given, by running Next on a copy of self. It is empty unless horizon is positive.
+
+Units in transit arrive, and the units in the nodes grow, starve and fight, by exactly the same rules as in the real game. Since only visible nodes are projected, the forecast of a state hidden by fog of war only knows about the units the player can see.
+*/
+func (self *State) Forecast(horizon int) (result Forecast) {
+	if horizon <= 0 {
+		return Forecast{}
+	}
+	result = make(Forecast, 0, horizon)
+	current := self.copy(false)
+	for len(result) < horizon {
+		current.Next(discardLogger, nil)
+		projection := Projection{
+			Units:    map[NodeId]map[PlayerId]int{},
+			Arrivals: map[NodeId]map[PlayerId]int{},
+		}
+		for nodeId, node := range current.Nodes {
+			for playerId, units := range node.Units {
+				if units > 0 {
+					if projection.Units[nodeId] == nil {
+						projection.Units[nodeId] = map[PlayerId]int{}
+					}
+					projection.Units[nodeId][playerId] = units
+				}
+			}
+		}
+		for _, event := range current.Events {
+			if arrived := event.UnitsArrived; arrived != nil {
+				if projection.Arrivals[arrived.Dst] == nil {
+					projection.Arrivals[arrived.Dst] = map[PlayerId]int{}
+				}
+				projection.Arrivals[arrived.Dst][arrived.Player] += arrived.Units
+			}
+		}
+		result = append(result, projection)
+	}
+	return
+}
@@ -0,0 +1,412 @@
+package state
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/zond/stockholm-ai/common"
+)
+
+/*
+MapGenerator creates the initial state of a game.
+*/
+type MapGenerator interface {
+	// Generate returns a new state with a start node for each of players, drawing all random decisions from r, or an error if it fails to.
+	Generate(c common.Logger, r *rand.Rand, rules Rules, players []PlayerId) (*State, error)
+}
+
+var mapGenerators = map[string]MapGenerator{}
+
+/*
+RegisterMapGenerator makes generator available under name.
+*/
+func RegisterMapGenerator(name string, generator MapGenerator) {
+	mapGenerators[name] = generator
+}
+
+func init() {
+	RegisterMapGenerator("random", RandomGenerator{})
+	RegisterMapGenerator("grid", GridGenerator{})
+	RegisterMapGenerator("hex", HexGenerator{})
+	RegisterMapGenerator("ring", RingGenerator{})
+	RegisterMapGenerator("continents", ContinentsGenerator{})
+	RegisterMapGenerator("symmetric", SymmetricGenerator{})
+	RegisterMapGenerator("fair", FairGenerator{})
+}
+
+/*
+MapGeneratorNames returns the names of all registered map generators, sorted.
+*/
+func MapGeneratorNames() (result []string) {
+	for name, _ := range mapGenerators {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return
+}
+
+/*
+GetMapGenerator returns the map generator registered as name. The empty name means "random".
+*/
+func GetMapGenerator(name string) (MapGenerator, error) {
+	if name == "" {
+		name = "random"
+	}
+	if generator, found := mapGenerators[name]; found {
+		return generator, nil
+	}
+	return nil, fmt.Errorf("No map generator named %#v, pick one of %v", name, MapGeneratorNames())
+}
+
+/*
+GenerateState creates a state for players using generator with a random source created with seed, and sets it to be played by rules.
+
+The same generator, seed, rules and players will always produce the same state. It returns an error if there are no players, or if the generator fails.
+*/
+func GenerateState(c common.Logger, generator MapGenerator, seed int64, rules Rules, players []PlayerId) (result *State, err error) {
+	if len(players) < 1 {
+		return nil, fmt.Errorf("Can't generate a map for %v players", len(players))
+	}
+	if result, err = generator.Generate(c, rand.New(rand.NewSource(seed)), rules, players); err != nil {
+		return nil, err
+	}
+	result.Seed = seed
+	result.Rules = rules
+	return
+}
+
+/*
+randomNodes adds num random nodes to s, and returns them in the order they were created.
+*/
+func randomNodes(r *rand.Rand, s *State, num int) (result []*Node) {
+	result = make([]*Node, 0, num)
+	for i := 0; i < num; i++ {
+		node := RandomNode(r)
+		s.Add(node)
+		result = append(result, node)
+	}
+	return
+}
+
+/*
+spreadNodes picks num nodes from candidates, starting with a random one and then always picking the one furthest away from the already picked ones.
+*/
+func spreadNodes(r *rand.Rand, s *State, candidates []*Node, num int) (result []*Node) {
+	picked := map[NodeId]bool{}
+	result = append(result, candidates[r.Intn(len(candidates))])
+	picked[result[0].Id] = true
+	for len(result) < num {
+		var best *Node
+		bestDist := -1
+		for _, candidate := range candidates {
+			if !picked[candidate.Id] {
+				dist := math.MaxInt32
+				for _, node := range result {
+					if path := s.Path(node.Id, candidate.Id, nil); len(path) < dist {
+						dist = len(path)
+					}
+				}
+				if dist > bestDist {
+					best, bestDist = candidate, dist
+				}
+			}
+		}
+		result = append(result, best)
+		picked[best.Id] = true
+	}
+	return
+}
+
+/*
+placeStarts puts the starting units of each of players on the start node with the same index, records the start nodes in s, and shrinks all start nodes to the size of the smallest of them.
+*/
+func placeStarts(s *State, rules Rules, starts []*Node, players []PlayerId) {
+	smallest := 100
+	for index, playerId := range players {
+		starts[index].Units[playerId] = rules.OrDefault().StartUnits
+		s.Starts[playerId] = starts[index].Id
+		if starts[index].Size < smallest {
+			smallest = starts[index].Size
+		}
+	}
+	for _, node := range starts {
+		node.Size = smallest
+	}
+}
+
+/*
+RandomGenerator creates a Norm sized random graph where nodes are connected randomly until everything is reachable.
+*/
+type RandomGenerator struct{}
+
+func (self RandomGenerator) Generate(c common.Logger, r *rand.Rand, rules Rules, players []PlayerId) (result *State, err error) {
+	result = NewState()
+	size := common.NormFrom(r, len(players)*6, len(players), len(players)*4, len(players)*10)
+	allNodes := randomNodes(r, result, size)
+	for _, node := range allNodes {
+		node.connectRandomly(c, r, allNodes, result)
+	}
+	perm := r.Perm(len(allNodes))
+	startNodes := make([]*Node, len(players))
+	maxEdges := 0
+	for index, _ := range players {
+		node := allNodes[perm[index]]
+		if len(node.Edges) > maxEdges {
+			maxEdges = len(node.Edges)
+		}
+		startNodes[index] = node
+	}
+	placeStarts(result, rules, startNodes, players)
+	for _, node := range startNodes {
+		node.connectMin(c, r, allNodes, result, maxEdges)
+	}
+	return
+}
+
+/*
+GridGenerator creates a rectangular lattice where each node is connected to the nodes above, below, left and right of it.
+*/
+type GridGenerator struct{}
+
+func (self GridGenerator) Generate(c common.Logger, r *rand.Rand, rules Rules, players []PlayerId) (result *State, err error) {
+	result = NewState()
+	size := common.NormFrom(r, len(players)*6, len(players), len(players)*4, len(players)*10)
+	width := int(math.Ceil(math.Sqrt(float64(size))))
+	height := (size + width - 1) / width
+	allNodes := randomNodes(r, result, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			node := allNodes[y*width+x]
+			if x+1 < width {
+				node.Connect(allNodes[y*width+x+1], common.NormFrom(r, 2, 1, 1, 4))
+			}
+			if y+1 < height {
+				node.Connect(allNodes[(y+1)*width+x], common.NormFrom(r, 2, 1, 1, 4))
+			}
+		}
+	}
+	placeStarts(result, rules, spreadNodes(r, result, allNodes, len(players)), players)
+	return
+}
+
+/*
+HexGenerator creates a hexagonal lattice where each node is connected to its six neighbours.
+*/
+type HexGenerator struct{}
+
+func (self HexGenerator) Generate(c common.Logger, r *rand.Rand, rules Rules, players []PlayerId) (result *State, err error) {
+	result = NewState()
+	size := common.NormFrom(r, len(players)*6, len(players), len(players)*4, len(players)*10)
+	width := int(math.Ceil(math.Sqrt(float64(size))))
+	height := (size + width - 1) / width
+	allNodes := randomNodes(r, result, width*height)
+	// using axial coordinates, where the neighbours of (x, y) are (x+1, y), (x, y+1) and (x-1, y+1) plus the reverse directions
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			node := allNodes[y*width+x]
+			if x+1 < width {
+				node.Connect(allNodes[y*width+x+1], common.NormFrom(r, 2, 1, 1, 4))
+			}
+			if y+1 < height {
+				node.Connect(allNodes[(y+1)*width+x], common.NormFrom(r, 2, 1, 1, 4))
+				if x > 0 {
+					node.Connect(allNodes[(y+1)*width+x-1], common.NormFrom(r, 2, 1, 1, 4))
+				}
+			}
+		}
+	}
+	placeStarts(result, rules, spreadNodes(r, result, allNodes, len(players)), players)
+	return
+}
+
+/*
+RingGenerator creates a single cycle of nodes, with the players spaced evenly along it.
+*/
+type RingGenerator struct{}
+
+func (self RingGenerator) Generate(c common.Logger, r *rand.Rand, rules Rules, players []PlayerId) (result *State, err error) {
+	result = NewState()
+	perPlayer := common.NormFrom(r, 5, 1, 3, 8)
+	allNodes := randomNodes(r, result, len(players)*perPlayer)
+	for index, node := range allNodes {
+		if next := allNodes[(index+1)%len(allNodes)]; next != node {
+			node.Connect(next, common.NormFrom(r, 2, 1, 1, 4))
+		}
+	}
+	offset := r.Intn(len(allNodes))
+	starts := make([]*Node, len(players))
+	for index, _ := range players {
+		starts[index] = allNodes[(offset+index*perPlayer)%len(allNodes)]
+	}
+	placeStarts(result, rules, starts, players)
+	return
+}
+
+/*
+ContinentsGenerator creates one densely connected cluster of nodes per player, joined by long edges.
+*/
+type ContinentsGenerator struct{}
+
+func (self ContinentsGenerator) Generate(c common.Logger, r *rand.Rand, rules Rules, players []PlayerId) (result *State, err error) {
+	result = NewState()
+	continents := make([][]*Node, len(players))
+	starts := make([]*Node, len(players))
+	for index, _ := range continents {
+		continent := randomNodes(r, result, common.NormFrom(r, 6, 1, 4, 8))
+		// connect each node to a random earlier node, to make the continent connected
+		for nodeIndex, node := range continent[1:] {
+			node.Connect(continent[r.Intn(nodeIndex+1)], common.NormFrom(r, 1, 1, 1, 2))
+		}
+		// and add some random extra edges to make it dense
+		for i := 0; i < len(continent); i++ {
+			src, dst := continent[r.Intn(len(continent))], continent[r.Intn(len(continent))]
+			if _, found := src.Edges[dst.Id]; !found && src != dst {
+				src.Connect(dst, common.NormFrom(r, 1, 1, 1, 2))
+			}
+		}
+		continents[index] = continent
+		starts[index] = continent[r.Intn(len(continent))]
+	}
+	// join the continents in a ring of long edges, plus a random long edge to another continent for each continent if there are more than two
+	for index, continent := range continents {
+		if len(continents) > 1 {
+			next := continents[(index+1)%len(continents)]
+			src, dst := continent[r.Intn(len(continent))], next[r.Intn(len(next))]
+			if _, found := src.Edges[dst.Id]; !found {
+				src.Connect(dst, common.NormFrom(r, 5, 1, 4, 7))
+			}
+		}
+		if len(continents) > 2 {
+			other := continents[(index+1+r.Intn(len(continents)-1))%len(continents)]
+			src, dst := continent[r.Intn(len(continent))], other[r.Intn(len(other))]
+			if _, found := src.Edges[dst.Id]; !found {
+				src.Connect(dst, common.NormFrom(r, 5, 1, 4, 7))
+			}
+		}
+	}
+	placeStarts(result, rules, starts, players)
+	return
+}
+
+/*
+SymmetricGenerator creates a rotationally symmetric map, made of one random sector per player where each sector is a copy of the others and is connected to the next sector the same way.
+*/
+type SymmetricGenerator struct{}
+
+func (self SymmetricGenerator) Generate(c common.Logger, r *rand.Rand, rules Rules, players []PlayerId) (result *State, err error) {
+	result, _ = generateSymmetric(r, rules, players, false)
+	return
+}
+
+/*
+FairGenerator creates maps like SymmetricGenerator, but with a contested neutral node in the center connected the same way to every sector.
+
+The rotation mapping each sector to the next is verified to be an automorphism of the map that maps each start node to the next one, so every player has exactly the same position.
+*/
+type FairGenerator struct{}
+
+func (self FairGenerator) Generate(c common.Logger, r *rand.Rand, rules Rules, players []PlayerId) (result *State, err error) {
+	result, rotation := generateSymmetric(r, rules, players, true)
+	if err = result.VerifyAutomorphism(rotation); err != nil {
+		return nil, err
+	}
+	for index, playerId := range players {
+		if next := players[(index+1)%len(players)]; rotation[result.Starts[playerId]] != result.Starts[next] {
+			return nil, fmt.Errorf("Rotation maps start of %v to %v, not to start of %v", playerId, rotation[result.Starts[playerId]], next)
+		}
+	}
+	return
+}
+
+/*
+generateSymmetric creates a map of one sector per player, each a copy of the same random template and connected to the next sector the same way, optionally with a center node connected to each sector.
+
+It returns the map, and the rotation that maps each node to the corresponding node of the next sector (and the center to itself).
+*/
+func generateSymmetric(r *rand.Rand, rules Rules, players []PlayerId, center bool) (result *State, rotation map[NodeId]NodeId) {
+	result = NewState()
+	sectorSize := common.NormFrom(r, 6, 1, 3, 10)
+	// create a template sector, with sizes and internal edges
+	sizes := make([]int, sectorSize)
+	for index, _ := range sizes {
+		sizes[index] = common.NormFrom(r, 50, 25, 10, 100)
+	}
+	type templateEdge struct {
+		src    int
+		dst    int
+		length int
+	}
+	internal := []templateEdge{}
+	for index := 1; index < sectorSize; index++ {
+		internal = append(internal, templateEdge{r.Intn(index), index, common.NormFrom(r, 2, 1, 1, 4)})
+	}
+	for i := 0; i < sectorSize/2; i++ {
+		if src, dst := r.Intn(sectorSize), r.Intn(sectorSize); src != dst {
+			internal = append(internal, templateEdge{src, dst, common.NormFrom(r, 2, 1, 1, 4)})
+		}
+	}
+	// and edges from each sector to the next, always including one from the first node to make sure everything is connected
+	external := []templateEdge{
+		templateEdge{0, r.Intn(sectorSize), common.NormFrom(r, 3, 1, 1, 5)},
+	}
+	for i := 0; i < sectorSize/3; i++ {
+		// skip edges that are the reverse of earlier ones, since with two players they would be the same edge with potentially different lengths
+		edge := templateEdge{r.Intn(sectorSize), r.Intn(sectorSize), common.NormFrom(r, 3, 1, 1, 5)}
+		reversed := false
+		for _, earlier := range external {
+			reversed = reversed || (earlier.src == edge.dst && earlier.dst == edge.src)
+		}
+		if !reversed {
+			external = append(external, edge)
+		}
+	}
+	// copy the template once per player
+	sectors := make([][]*Node, len(players))
+	for index, _ := range sectors {
+		sectors[index] = randomNodes(r, result, sectorSize)
+		for nodeIndex, node := range sectors[index] {
+			node.Size = sizes[nodeIndex]
+		}
+	}
+	for index, sector := range sectors {
+		for _, edge := range internal {
+			if _, found := sector[edge.src].Edges[sector[edge.dst].Id]; !found {
+				sector[edge.src].Connect(sector[edge.dst], edge.length)
+			}
+		}
+		if len(sectors) > 1 {
+			next := sectors[(index+1)%len(sectors)]
+			for _, edge := range external {
+				if _, found := sector[edge.src].Edges[next[edge.dst].Id]; !found {
+					sector[edge.src].Connect(next[edge.dst], edge.length)
+				}
+			}
+		}
+	}
+	rotation = map[NodeId]NodeId{}
+	for index, sector := range sectors {
+		next := sectors[(index+1)%len(sectors)]
+		for nodeIndex, node := range sector {
+			rotation[node.Id] = next[nodeIndex].Id
+		}
+	}
+	if center {
+		// connect the center to the same node in each sector, but never to the start node
+		centerNode := RandomNode(r)
+		result.Add(centerNode)
+		rotation[centerNode.Id] = centerNode.Id
+		spoke := 1 + r.Intn(sectorSize-1)
+		length := common.NormFrom(r, 3, 1, 2, 5)
+		for _, sector := range sectors {
+			sector[spoke].Connect(centerNode, length)
+		}
+	}
+	starts := make([]*Node, len(players))
+	for index, sector := range sectors {
+		starts[index] = sector[0]
+	}
+	placeStarts(result, rules, starts, players)
+	return
+}
@@ -0,0 +1,400 @@
+package state
+
+import (
+	"container/heap"
+	"sort"
+)
+
+/*
+EdgeCost returns the cost of moving units along edge, or a negative number if they can't use it.
+*/
+type EdgeCost func(edge Edge) float64
+
+/*
+Distance is the number of turns it takes units to get to the destination of edge, which is one more than its length. It is the cost used by State.Path and DistanceMatrix.
+*/
+func Distance(edge Edge) float64 {
+	return float64(len(edge.Units) + 1)
+}
+
+/*
+EnemyCost returns an edge cost that is the Distance of the edge, plus weight for each unit not belonging to me at the destination of the edge or in transit along it.
+*/
+func (self *State) EnemyCost(me PlayerId, weight float64) EdgeCost {
+	return func(edge Edge) (result float64) {
+		result = Distance(edge)
+		if dst, found := self.Nodes[edge.Dst]; found {
+			for playerId, units := range dst.Units {
+				if playerId != me {
+					result += weight * float64(units)
+				}
+			}
+		}
+		for _, spot := range edge.Units {
+			for playerId, units := range spot {
+				if playerId != me {
+					result += weight * float64(units)
+				}
+			}
+		}
+		return
+	}
+}
+
+/*
+Route is a way from one node to another.
+*/
+type Route struct {
+	// Nodes are the nodes along the route, starting with the source and ending with the destination.
+	Nodes []NodeId
+	// Cost is the sum of the costs of the edges along the route.
+	Cost float64
+}
+
+/*
+Next returns the node to send units to to follow the route, or "" if the route doesn't go anywhere.
+*/
+func (self Route) Next() NodeId {
+	if len(self.Nodes) < 2 {
+		return ""
+	}
+	return self.Nodes[1]
+}
+
+/*
+ShortestPaths contains the cheapest routes from one node to all nodes reachable from it.
+*/
+type ShortestPaths struct {
+	// Src is the node the routes start at.
+	Src NodeId
+	// Costs are the costs of the cheapest routes to each reachable node.
+	Costs    map[NodeId]float64
+	previous map[NodeId]NodeId
+}
+
+/*
+Route returns the cheapest route to dst, and whether there is one.
+*/
+func (self *ShortestPaths) Route(dst NodeId) (result Route, found bool) {
+	if result.Cost, found = self.Costs[dst]; !found {
+		return
+	}
+	for nodeId := dst; nodeId != self.Src; nodeId = self.previous[nodeId] {
+		result.Nodes = append(result.Nodes, nodeId)
+	}
+	result.Nodes = append(result.Nodes, self.Src)
+	for i, j := 0, len(result.Nodes)-1; i < j; i, j = i+1, j-1 {
+		result.Nodes[i], result.Nodes[j] = result.Nodes[j], result.Nodes[i]
+	}
+	return
+}
+
+type costItem struct {
+	node NodeId
+	cost float64
+}
+
+type costQueue []costItem
+
+func (self costQueue) Len() int {
+	return len(self)
+}
+
+func (self costQueue) Less(i, j int) bool {
+	if self[i].cost != self[j].cost {
+		return self[i].cost < self[j].cost
+	}
+	return self[i].node < self[j].node
+}
+
+func (self costQueue) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+func (self *costQueue) Push(x interface{}) {
+	*self = append(*self, x.(costItem))
+}
+
+func (self *costQueue) Pop() (result interface{}) {
+	result = (*self)[len(*self)-1]
+	*self = (*self)[:len(*self)-1]
+	return
+}
+
+/*
+ShortestPaths finds the cheapest routes from src to all nodes using Dijkstra's algorithm, with Distance as cost if cost is nil.
+
+Like Path, it finds routes to nodes that are the destinations of edges but not in the state, such as nodes hidden by fog of war, but not through them.
+*/
+func (self *State) ShortestPaths(src NodeId, cost EdgeCost) *ShortestPaths {
+	return self.shortestPaths(src, cost, nil)
+}
+
+func (self *State) shortestPaths(src NodeId, cost EdgeCost, skip func(edge Edge) bool) (result *ShortestPaths) {
+	if cost == nil {
+		cost = Distance
+	}
+	result = &ShortestPaths{
+		Src: src,
+		Costs: map[NodeId]float64{
+			src: 0,
+		},
+		previous: map[NodeId]NodeId{},
+	}
+	done := map[NodeId]bool{}
+	queue := &costQueue{costItem{node: src}}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(costItem)
+		if done[item.node] {
+			continue
+		}
+		done[item.node] = true
+		node, found := self.Nodes[item.node]
+		if !found {
+			continue
+		}
+		for _, dst := range node.EdgeIds() {
+			edge := node.Edges[dst]
+			if skip != nil && skip(edge) {
+				continue
+			}
+			edgeCost := cost(edge)
+			if edgeCost < 0 {
+				continue
+			}
+			if previousCost, found := result.Costs[dst]; !found || item.cost+edgeCost < previousCost {
+				result.Costs[dst] = item.cost + edgeCost
+				result.previous[dst] = item.node
+				heap.Push(queue, costItem{node: dst, cost: item.cost + edgeCost})
+			}
+		}
+	}
+	return
+}
+
+/*
+DistanceMatrix contains the distances between all nodes of a state, and the first step of the shortest paths between them.
+*/
+type DistanceMatrix struct {
+	index     map[NodeId]int
+	distances [][]int
+	next      [][]NodeId
+}
+
+/*
+Distance returns the number of turns it takes units to get from src to dst, and whether they can get there at all.
+*/
+func (self *DistanceMatrix) Distance(src, dst NodeId) (distance int, found bool) {
+	srcIndex, srcFound := self.index[src]
+	dstIndex, dstFound := self.index[dst]
+	if !srcFound || !dstFound || self.distances[srcIndex][dstIndex] < 0 {
+		return 0, false
+	}
+	return self.distances[srcIndex][dstIndex], true
+}
+
+/*
+Next returns the node to send units from src to to get them to dst as fast as possible, or "" if they can't get there or already are there.
+*/
+func (self *DistanceMatrix) Next(src, dst NodeId) NodeId {
+	srcIndex, srcFound := self.index[src]
+	dstIndex, dstFound := self.index[dst]
+	if !srcFound || !dstFound {
+		return ""
+	}
+	return self.next[srcIndex][dstIndex]
+}
+
+/*
+Distances returns the distance matrix of self.
+
+It is computed the first time it is needed, and kept until nodes are added to self using Add or connected using Connect. It is safe to call from several goroutines at once.
+*/
+func (self *State) Distances() *DistanceMatrix {
+	self.distancesLock.Lock()
+	defer self.distancesLock.Unlock()
+	if self.distances == nil {
+		// nodes decoded from JSON don't know which state they belong to
+		for _, node := range self.Nodes {
+			node.state = self
+		}
+		ids := self.NodeIds()
+		result := &DistanceMatrix{
+			index:     map[NodeId]int{},
+			distances: make([][]int, len(ids)),
+			next:      make([][]NodeId, len(ids)),
+		}
+		for index, nodeId := range ids {
+			result.index[nodeId] = index
+		}
+		for srcIndex, src := range ids {
+			paths := self.ShortestPaths(src, nil)
+			result.distances[srcIndex] = make([]int, len(ids))
+			result.next[srcIndex] = make([]NodeId, len(ids))
+			for dstIndex, dst := range ids {
+				if route, found := paths.Route(dst); found {
+					result.distances[srcIndex][dstIndex] = int(route.Cost)
+					result.next[srcIndex][dstIndex] = route.Next()
+				} else {
+					result.distances[srcIndex][dstIndex] = -1
+				}
+			}
+		}
+		self.distances = result
+	}
+	return self.distances
+}
+
+/*
+KShortestRoutes returns the k cheapest routes from src to dst that don't visit any node twice, cheapest first, using Yen's algorithm with Distance as cost if cost is nil.
+*/
+func (self *State) KShortestRoutes(src, dst NodeId, k int, cost EdgeCost) (result []Route) {
+	if cost == nil {
+		cost = Distance
+	}
+	first, found := self.ShortestPaths(src, cost).Route(dst)
+	if !found || k < 1 {
+		return
+	}
+	result = []Route{first}
+	candidates := []Route{}
+	seen := map[string]bool{
+		routeKey(first): true,
+	}
+	for len(result) < k {
+		last := result[len(result)-1]
+		for spurIndex := 0; spurIndex < len(last.Nodes)-1; spurIndex++ {
+			spur := last.Nodes[spurIndex]
+			root := last.Nodes[:spurIndex+1]
+			removedEdges := map[[2]NodeId]bool{}
+			for _, route := range result {
+				if len(route.Nodes) > spurIndex+1 && sameNodes(route.Nodes[:spurIndex+1], root) {
+					removedEdges[[2]NodeId{route.Nodes[spurIndex], route.Nodes[spurIndex+1]}] = true
+				}
+			}
+			removedNodes := map[NodeId]bool{}
+			for _, nodeId := range root[:spurIndex] {
+				removedNodes[nodeId] = true
+			}
+			spurRoute, found := self.shortestPaths(spur, cost, func(edge Edge) bool {
+				return removedEdges[[2]NodeId{edge.Src, edge.Dst}] || removedNodes[edge.Dst]
+			}).Route(dst)
+			if !found {
+				continue
+			}
+			candidate := Route{
+				Nodes: append(append([]NodeId{}, root...), spurRoute.Nodes[1:]...),
+				Cost:  spurRoute.Cost,
+			}
+			for index := 0; index < spurIndex; index++ {
+				candidate.Cost += cost(self.Nodes[root[index]].Edges[root[index+1]])
+			}
+			if key := routeKey(candidate); !seen[key] {
+				seen[key] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].Cost != candidates[j].Cost {
+				return candidates[i].Cost < candidates[j].Cost
+			}
+			return routeKey(candidates[i]) < routeKey(candidates[j])
+		})
+		result = append(result, candidates[0])
+		candidates = candidates[1:]
+	}
+	return
+}
+
+func sameNodes(a, b []NodeId) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index, nodeId := range a {
+		if b[index] != nodeId {
+			return false
+		}
+	}
+	return true
+}
+
+func routeKey(route Route) (result string) {
+	for _, nodeId := range route.Nodes {
+		result += string(nodeId) + "\x00"
+	}
+	return
+}
+
+/*
+Frontier returns the nodes where me has units that are connected to nodes where me has none, in canonical order.
+*/
+func (self *State) Frontier(me PlayerId) (result NodeIds) {
+	result = NodeIds{}
+	for _, nodeId := range self.NodeIds() {
+		node := self.Nodes[nodeId]
+		if node.Units[me] == 0 {
+			continue
+		}
+		for _, dst := range node.EdgeIds() {
+			if other, found := self.Nodes[dst]; !found || other.Units[me] == 0 {
+				result = append(result, nodeId)
+				break
+			}
+		}
+	}
+	return
+}
+
+/*
+Chokepoints returns the nodes that, if removed, would split the nodes connected through them into groups that can't reach each other, in canonical order.
+*/
+func (self *State) Chokepoints() (result NodeIds) {
+	result = NodeIds{}
+	discovered := map[NodeId]int{}
+	low := map[NodeId]int{}
+	chokepoint := map[NodeId]bool{}
+	time := 0
+	var visit func(nodeId, parent NodeId)
+	visit = func(nodeId, parent NodeId) {
+		time++
+		discovered[nodeId] = time
+		low[nodeId] = time
+		children := 0
+		for _, dst := range self.Nodes[nodeId].EdgeIds() {
+			if _, found := self.Nodes[dst]; !found || dst == parent {
+				continue
+			}
+			if discovered[dst] > 0 {
+				if discovered[dst] < low[nodeId] {
+					low[nodeId] = discovered[dst]
+				}
+				continue
+			}
+			children++
+			visit(dst, nodeId)
+			if low[dst] < low[nodeId] {
+				low[nodeId] = low[dst]
+			}
+			if parent != "" && low[dst] >= discovered[nodeId] {
+				chokepoint[nodeId] = true
+			}
+		}
+		if parent == "" && children > 1 {
+			chokepoint[nodeId] = true
+		}
+	}
+	for _, nodeId := range self.NodeIds() {
+		if discovered[nodeId] == 0 {
+			visit(nodeId, "")
+		}
+	}
+	for _, nodeId := range self.NodeIds() {
+		if chokepoint[nodeId] {
+			result = append(result, nodeId)
+		}
+	}
+	return
+}
@@ -0,0 +1,216 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+/*
+Map is a human editable description of a board, meant to be stored as JSON in files or in the map pool of a hub.
+
+A map for two players with three nodes in a line looks like this:
+
+	{
+	  "Name": "line",
+	  "Nodes": [
+	    {"Id": "west", "Size": 40},
+	    {"Id": "middle", "Size": 80},
+	    {"Id": "east", "Size": 40}
+	  ],
+	  "Edges": [
+	    {"Src": "west", "Dst": "middle", "Length": 2},
+	    {"Src": "middle", "Dst": "east", "Length": 2}
+	  ],
+	  "Starts": ["west", "east"]
+	}
+
+Edges go both ways, so each pair of nodes should only be connected once.
+*/
+type Map struct {
+	// Name is the name of the map.
+	Name string
+	// Nodes are the nodes of the map.
+	Nodes []MapNode
+	// Edges are the connections between the nodes.
+	Edges []MapEdge
+	// Starts are the start slots of the map, one per player. Games with fewer players than slots use the first slots.
+	Starts []NodeId
+}
+
+/*
+MapNode describes a node in a map.
+*/
+type MapNode struct {
+	// Id is the id of the node.
+	Id NodeId
+	// Size is the size of the node.
+	Size int
+}
+
+/*
+MapEdge describes a connection between two nodes in a map.
+*/
+type MapEdge struct {
+	// Src is one end of the connection.
+	Src NodeId
+	// Dst is the other end of the connection.
+	Dst NodeId
+	// Length is the number of turns it takes to travel along the connection.
+	Length int
+}
+
+/*
+LoadMap reads a JSON encoded map from r, and returns it if it is valid.
+*/
+func LoadMap(r io.Reader) (result *Map, err error) {
+	result = &Map{}
+	if err = json.NewDecoder(r).Decode(result); err != nil {
+		return nil, err
+	}
+	if err = result.Validate(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+/*
+SaveMap writes m as indented JSON to w.
+*/
+func SaveMap(w io.Writer, m *Map) (err error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(b); err != nil {
+		return
+	}
+	_, err = io.WriteString(w, "\n")
+	return
+}
+
+/*
+Validate returns an error unless self describes a connected board with at least one start slot.
+*/
+func (self *Map) Validate() error {
+	if self.Name == "" {
+		return fmt.Errorf("Map has no name")
+	}
+	if len(self.Nodes) == 0 {
+		return fmt.Errorf("Map %v has no nodes", self.Name)
+	}
+	if len(self.Starts) == 0 {
+		return fmt.Errorf("Map %v has no start slots", self.Name)
+	}
+	s := NewState()
+	for _, node := range self.Nodes {
+		if node.Id == "" {
+			return fmt.Errorf("Map %v has a node without id", self.Name)
+		}
+		if _, found := s.Nodes[node.Id]; found {
+			return fmt.Errorf("Map %v has more than one node %v", self.Name, node.Id)
+		}
+		if node.Size < 1 {
+			return fmt.Errorf("Node %v in map %v must have a positive size, not %v", node.Id, self.Name, node.Size)
+		}
+		s.Add(NewNode(node.Id, node.Size))
+	}
+	for _, edge := range self.Edges {
+		src, found := s.Nodes[edge.Src]
+		if !found {
+			return fmt.Errorf("Map %v has an edge from unknown node %v", self.Name, edge.Src)
+		}
+		dst, found := s.Nodes[edge.Dst]
+		if !found {
+			return fmt.Errorf("Map %v has an edge to unknown node %v", self.Name, edge.Dst)
+		}
+		if src == dst {
+			return fmt.Errorf("Map %v has an edge from %v to itself", self.Name, edge.Src)
+		}
+		if _, found := src.Edges[dst.Id]; found {
+			return fmt.Errorf("Map %v connects %v and %v more than once", self.Name, edge.Src, edge.Dst)
+		}
+		if edge.Length < 1 {
+			return fmt.Errorf("The edge between %v and %v in map %v must have a positive length, not %v", edge.Src, edge.Dst, self.Name, edge.Length)
+		}
+		src.Connect(dst, edge.Length)
+	}
+	starts := map[NodeId]bool{}
+	for _, start := range self.Starts {
+		if _, found := s.Nodes[start]; !found {
+			return fmt.Errorf("Map %v has a start slot at unknown node %v", self.Name, start)
+		}
+		if starts[start] {
+			return fmt.Errorf("Map %v has more than one start slot at %v", self.Name, start)
+		}
+		starts[start] = true
+	}
+	for _, node := range s.Nodes {
+		if !node.allReachable(nil, s) {
+			return fmt.Errorf("Not all nodes in map %v are reachable from %v", self.Name, node.Id)
+		}
+	}
+	return nil
+}
+
+/*
+State returns a new state on this map for players, played by rules.
+
+Player number N starts at start slot number N, so there can't be more players than start slots.
+*/
+func (self *Map) State(rules Rules, players []PlayerId) (result *State, err error) {
+	if err = self.Validate(); err != nil {
+		return
+	}
+	if len(players) > len(self.Starts) {
+		return nil, fmt.Errorf("Map %v only has %v start slots, not %v", self.Name, len(self.Starts), len(players))
+	}
+	result = NewState()
+	result.Rules = rules
+	for _, node := range self.Nodes {
+		result.Add(NewNode(node.Id, node.Size))
+	}
+	for _, edge := range self.Edges {
+		result.Nodes[edge.Src].Connect(result.Nodes[edge.Dst], edge.Length)
+	}
+	for index, playerId := range players {
+		result.Nodes[self.Starts[index]].Units[playerId] = rules.OrDefault().StartUnits
+		result.Starts[playerId] = self.Starts[index]
+	}
+	return
+}
+
+/*
+Map returns the board of self as a map named name, with nodes and edges in canonical order and one start slot per player in Starts (ordered by player id).
+*/
+func (self *State) Map(name string) (result *Map) {
+	result = &Map{
+		Name: name,
+	}
+	for _, nodeId := range self.NodeIds() {
+		node := self.Nodes[nodeId]
+		result.Nodes = append(result.Nodes, MapNode{
+			Id:   nodeId,
+			Size: node.Size,
+		})
+		for _, dst := range node.EdgeIds() {
+			if nodeId < dst {
+				result.Edges = append(result.Edges, MapEdge{
+					Src:    nodeId,
+					Dst:    dst,
+					Length: len(node.Edges[dst].Units),
+				})
+			}
+		}
+	}
+	playerIds := make(PlayerIds, 0, len(self.Starts))
+	for playerId, _ := range self.Starts {
+		playerIds = append(playerIds, playerId)
+	}
+	sort.Sort(playerIds)
+	for _, playerId := range playerIds {
+		result.Starts = append(result.Starts, self.Starts[playerId])
+	}
+	return
+}
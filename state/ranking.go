@@ -0,0 +1,140 @@
+package state
+
+import (
+	"sort"
+)
+
+/*
+Placement is how well a player did in a game.
+*/
+type Placement struct {
+	Player PlayerId
+	// Rank is 1 for the best placed players. Players with equal units, nodes and elimination turn share rank.
+	Rank int
+	// Units is the number of units of the player, on nodes and in transit.
+	Units int
+	// Nodes is the number of nodes where the player is the only one with units.
+	Nodes int
+	// Eliminated is the turn the player lost its last unit, or 0 if it still has units.
+	Eliminated int
+}
+
+/*
+Placements are sorted from best to worst.
+
+Players with units beat players without, and are ranked by units and then by nodes. Players without units are ranked by how late they were eliminated.
+*/
+type Placements []Placement
+
+func (self Placements) Len() int {
+	return len(self)
+}
+
+func (self Placements) better(i, j int) bool {
+	if (self[i].Units > 0) != (self[j].Units > 0) {
+		return self[i].Units > 0
+	}
+	if self[i].Units != self[j].Units {
+		return self[i].Units > self[j].Units
+	}
+	if self[i].Nodes != self[j].Nodes {
+		return self[i].Nodes > self[j].Nodes
+	}
+	return self[i].Eliminated > self[j].Eliminated
+}
+
+func (self Placements) Less(i, j int) bool {
+	if self.better(i, j) {
+		return true
+	}
+	if self.better(j, i) {
+		return false
+	}
+	return self[i].Player < self[j].Player
+}
+
+func (self Placements) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+Winners returns the players with rank 1. More than one means a draw.
+*/
+func (self Placements) Winners() (result PlayerIds) {
+	for _, placement := range self {
+		if placement.Rank == 1 {
+			result = append(result, placement.Player)
+		}
+	}
+	return
+}
+
+/*
+Units returns the number of units of each player, on nodes and in transit.
+*/
+func (self *State) Units() (result map[PlayerId]int) {
+	result = map[PlayerId]int{}
+	for _, node := range self.Nodes {
+		for playerId, units := range node.Units {
+			result[playerId] += units
+		}
+		for _, edge := range node.Edges {
+			for _, spot := range edge.Units {
+				for playerId, units := range spot {
+					result[playerId] += units
+				}
+			}
+		}
+	}
+	return
+}
+
+/*
+Control returns the number of nodes where each player is the only one with units.
+*/
+func (self *State) Control() (result map[PlayerId]int) {
+	result = map[PlayerId]int{}
+	for _, node := range self.Nodes {
+		var owner PlayerId
+		owners := 0
+		for playerId, units := range node.Units {
+			if units > 0 {
+				owner = playerId
+				owners++
+			}
+		}
+		if owners == 1 {
+			result[owner]++
+		}
+	}
+	return
+}
+
+/*
+Ranking returns the placements of players in self, given the turn each of them was eliminated, which usually is self.Eliminated.
+*/
+func (self *State) Ranking(players []PlayerId, eliminated map[PlayerId]int) (result Placements) {
+	units := self.Units()
+	control := self.Control()
+	result = make(Placements, 0, len(players))
+	for _, playerId := range players {
+		placement := Placement{
+			Player: playerId,
+			Units:  units[playerId],
+			Nodes:  control[playerId],
+		}
+		if placement.Units == 0 {
+			placement.Eliminated = eliminated[playerId]
+		}
+		result = append(result, placement)
+	}
+	sort.Sort(result)
+	for index, _ := range result {
+		if index > 0 && !result.better(index-1, index) {
+			result[index].Rank = result[index-1].Rank
+		} else {
+			result[index].Rank = index + 1
+		}
+	}
+	return
+}
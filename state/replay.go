@@ -0,0 +1,243 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/zond/stockholm-ai/common"
+)
+
+/*
+ReplayTurn is what happened in one turn of a replay.
+*/
+type ReplayTurn struct {
+	// Ordinal is the ordinal of the turn. The initial state has ordinal 0.
+	Ordinal int
+	// Orders are the orders each player gave for this turn.
+	Orders map[PlayerId]Orders
+	// Changes are the changes the orders, transits, growth and conflicts of this turn caused.
+	Changes map[NodeId]Changes
+	// Events are the events of this turn. Replays exported before turns had events don't have them.
+	Events Events `json:",omitempty"`
+}
+
+/*
+Replay is everything needed to watch a game again, or to verify that it was played by the rules.
+*/
+type Replay struct {
+	// Seed is the seed the initial state was generated with.
+	Seed int64
+	// Rules are the rules the game was played by.
+	Rules Rules
+	// Generator is the name of the map generator the initial state was generated with, if it wasn't created from a map.
+	Generator string `json:",omitempty"`
+	// Map is the name of the map the initial state was created from, if any.
+	Map string `json:",omitempty"`
+	// Players are the players of the game.
+	Players []PlayerId
+	// PlayerNames are the names of the players, in the same order.
+	PlayerNames []string
+	// Initial is the state before the first turn.
+	Initial *State
+	// Turns are the turns played after the initial state.
+	Turns []ReplayTurn
+	// Placements are the final ranking of the players, if the game finished.
+	Placements Placements `json:",omitempty"`
+}
+
+/*
+NewReplay returns a replay of states, which must start with the initial state and be ordered by turn.
+*/
+func NewReplay(states []*State) (result *Replay) {
+	result = &Replay{
+		Seed:    states[0].Seed,
+		Rules:   states[0].Rules,
+		Initial: states[0],
+	}
+	for index, s := range states[1:] {
+		result.Turns = append(result.Turns, ReplayTurn{
+			Ordinal: index + 1,
+			Orders:  s.Orders,
+			Changes: s.Changes,
+			Events:  s.Events,
+		})
+	}
+	return
+}
+
+/*
+normalizeJSON removes empty objects and arrays from v, a decoded JSON value, so that nil and empty maps and slices compare equal.
+*/
+func normalizeJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if normalized := normalizeJSON(value); normalized == nil {
+				delete(t, key)
+			} else {
+				t[key] = normalized
+			}
+		}
+		if len(t) == 0 {
+			return nil
+		}
+	case []interface{}:
+		for index, value := range t {
+			t[index] = normalizeJSON(value)
+		}
+		if len(t) == 0 {
+			return nil
+		}
+	}
+	return v
+}
+
+/*
+sameJSON returns whether a and b encode to the same JSON, not counting empty objects and arrays.
+*/
+func sameJSON(a, b interface{}) bool {
+	decoded := make([]interface{}, 2)
+	for index, v := range []interface{}{a, b} {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		if err = json.Unmarshal(encoded, &decoded[index]); err != nil {
+			return false
+		}
+	}
+	return reflect.DeepEqual(normalizeJSON(decoded[0]), normalizeJSON(decoded[1]))
+}
+
+/*
+checkStructure returns an error unless self is a well formed state that Next can run on, with consistent ids, positive sizes, non negative units and edges and starts only pointing at its own nodes.
+*/
+func (self *State) checkStructure() error {
+	if len(self.Nodes) == 0 {
+		return fmt.Errorf("State has no nodes")
+	}
+	checkUnits := func(units map[PlayerId]int, where string) error {
+		if units == nil {
+			return fmt.Errorf("%v has no units", where)
+		}
+		for playerId, num := range units {
+			if num < 0 {
+				return fmt.Errorf("%v has %v units of %v", where, num, playerId)
+			}
+		}
+		return nil
+	}
+	for nodeId, node := range self.Nodes {
+		if node == nil || node.Id != nodeId {
+			return fmt.Errorf("Node %v has the wrong id", nodeId)
+		}
+		if node.Size < 1 {
+			return fmt.Errorf("Node %v must have a positive size, not %v", nodeId, node.Size)
+		}
+		if err := checkUnits(node.Units, fmt.Sprintf("Node %v", nodeId)); err != nil {
+			return err
+		}
+		for dst, edge := range node.Edges {
+			if edge.Src != nodeId || edge.Dst != dst {
+				return fmt.Errorf("The edge from %v to %v has the wrong ends", nodeId, dst)
+			}
+			if _, found := self.Nodes[dst]; !found || dst == nodeId {
+				return fmt.Errorf("The edge from %v leads to unknown node %v", nodeId, dst)
+			}
+			if len(edge.Units) < 1 {
+				return fmt.Errorf("The edge from %v to %v must have a positive length", nodeId, dst)
+			}
+			for _, spot := range edge.Units {
+				if err := checkUnits(spot, fmt.Sprintf("The edge from %v to %v", nodeId, dst)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for playerId, nodeId := range self.Starts {
+		if _, found := self.Nodes[nodeId]; !found {
+			return fmt.Errorf("%v starts at unknown node %v", playerId, nodeId)
+		}
+	}
+	return nil
+}
+
+/*
+States re-simulates self using Next, and returns the initial state followed by the state after each turn.
+
+It returns an error if the initial state isn't well formed, if its rules aren't the ones of the replay, if it isn't the one the generator creates from the seed, if a turn caused other changes or events than the replay says, if turns go on after the game ended, or if the placements aren't the ones of the final state.
+*/
+func (self *Replay) States(c common.Logger) (result []*State, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			result = nil
+			err = fmt.Errorf("Replay can't be played: %v", e)
+		}
+	}()
+	if self.Initial == nil {
+		return nil, fmt.Errorf("Replay has no initial state")
+	}
+	if err = self.Initial.checkStructure(); err != nil {
+		return nil, err
+	}
+	if len(self.Players) == 0 {
+		return nil, fmt.Errorf("Replay has no players")
+	}
+	if self.Rules.OrDefault() != self.Initial.Rules.OrDefault() {
+		return nil, fmt.Errorf("Replay says the rules are %+v, but its initial state has %+v", self.Rules.OrDefault(), self.Initial.Rules.OrDefault())
+	}
+	if self.Map == "" {
+		generator, err := GetMapGenerator(self.Generator)
+		if err != nil {
+			return nil, err
+		}
+		generated, err := GenerateState(c, generator, self.Seed, self.Rules, self.Players)
+		if err != nil {
+			return nil, err
+		}
+		if !sameJSON(generated, self.Initial) {
+			return nil, fmt.Errorf("Initial state isn't the one generated by %#v with seed %v", self.Generator, self.Seed)
+		}
+	}
+	maxTurns := self.Rules.OrDefault().MaxTurns
+	current := self.Initial.Clone()
+	result = append(result, current.Clone())
+	var winner *PlayerId
+	for index, turn := range self.Turns {
+		if turn.Ordinal != index+1 {
+			return nil, fmt.Errorf("Turn %v has ordinal %v", index+1, turn.Ordinal)
+		}
+		if winner != nil {
+			return nil, fmt.Errorf("Turn %v was played after %v won", turn.Ordinal, *winner)
+		}
+		if turn.Ordinal > maxTurns {
+			return nil, fmt.Errorf("Turn %v was played after the game ended after %v turns", turn.Ordinal, maxTurns)
+		}
+		winner = current.Next(c, turn.Orders)
+		if !sameJSON(current.Changes, turn.Changes) {
+			return nil, fmt.Errorf("Turn %v caused other changes than the replay says", turn.Ordinal)
+		}
+		if turn.Events != nil && !sameJSON(current.Events, turn.Events) {
+			return nil, fmt.Errorf("Turn %v caused other events than the replay says", turn.Ordinal)
+		}
+		result = append(result, current.Clone())
+	}
+	if len(self.Placements) > 0 {
+		if winner == nil && len(self.Turns) < maxTurns {
+			return nil, fmt.Errorf("Replay has placements, but the game didn't end")
+		}
+		if !sameJSON(current.Ranking(self.Players, current.Eliminated), self.Placements) {
+			return nil, fmt.Errorf("Placements aren't the ones of the final state")
+		}
+	}
+	return
+}
+
+/*
+Validate returns an error if self wasn't played by the rules, as described by States.
+*/
+func (self *Replay) Validate(c common.Logger) (err error) {
+	_, err = self.States(c)
+	return
+}
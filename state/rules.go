@@ -0,0 +1,72 @@
+package state
+
+import (
+	"fmt"
+)
+
+/*
+Rules contains the tunable parameters of a game.
+
+Rules where all numeric parameters are zero mean the default rules, to stay compatible with states created before rules were configurable.
+*/
+type Rules struct {
+	// GrowthFactor decides how fast units procreate on nodes with room to spare.
+	GrowthFactor float64
+	// StarvationFactor decides how fast units starve on nodes with more units than size.
+	StarvationFactor float64
+	// ConflictDivisor decides how bloody conflicts are. Each turn, each player on a contested node loses the number of enemy units there divided by this.
+	ConflictDivisor float64
+	// StartUnits is the number of units each player starts with.
+	StartUnits int
+	// MaxTurns is the number of turns after which the game ends.
+	MaxTurns int
+	// FogOfWar makes each AI see only the nodes it occupies or borders, instead of the entire state.
+	FogOfWar bool
+}
+
+/*
+DefaultRules returns the rules used when nothing else is specified.
+*/
+func DefaultRules() Rules {
+	return Rules{
+		GrowthFactor:     0.2,
+		StarvationFactor: 0.2,
+		ConflictDivisor:  5.0,
+		StartUnits:       10,
+		MaxTurns:         100,
+	}
+}
+
+/*
+OrDefault returns self, or the default rules (keeping the flags of self) if all numeric parameters of self are zero.
+*/
+func (self Rules) OrDefault() Rules {
+	if self.GrowthFactor == 0 && self.StarvationFactor == 0 && self.ConflictDivisor == 0 && self.StartUnits == 0 && self.MaxTurns == 0 {
+		result := DefaultRules()
+		result.FogOfWar = self.FogOfWar
+		return result
+	}
+	return self
+}
+
+/*
+Validate returns an error if self can't be used to play a game.
+*/
+func (self Rules) Validate() error {
+	if self.GrowthFactor < 0 {
+		return fmt.Errorf("GrowthFactor must be >= 0, not %v", self.GrowthFactor)
+	}
+	if self.StarvationFactor < 0 {
+		return fmt.Errorf("StarvationFactor must be >= 0, not %v", self.StarvationFactor)
+	}
+	if self.ConflictDivisor <= 0 {
+		return fmt.Errorf("ConflictDivisor must be > 0, not %v", self.ConflictDivisor)
+	}
+	if self.StartUnits < 1 {
+		return fmt.Errorf("StartUnits must be > 0, not %v", self.StartUnits)
+	}
+	if self.MaxTurns < 1 {
+		return fmt.Errorf("MaxTurns must be > 0, not %v", self.MaxTurns)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package state
+
+func copyUnits(units map[PlayerId]int) (result map[PlayerId]int) {
+	if units == nil {
+		return nil
+	}
+	result = make(map[PlayerId]int, len(units))
+	for playerId, num := range units {
+		result[playerId] = num
+	}
+	return
+}
+
+func (self *State) copy(history bool) (result *State) {
+	self.distancesLock.Lock()
+	result = &State{
+		Seed:      self.Seed,
+		Turn:      self.Turn,
+		Rules:     self.Rules,
+		distances: self.distances,
+	}
+	self.distancesLock.Unlock()
+	if self.Eliminated != nil {
+		result.Eliminated = make(map[PlayerId]int, len(self.Eliminated))
+		for playerId, turn := range self.Eliminated {
+			result.Eliminated[playerId] = turn
+		}
+	}
+	if self.Starts != nil {
+		result.Starts = make(map[PlayerId]NodeId, len(self.Starts))
+		for playerId, nodeId := range self.Starts {
+			result.Starts[playerId] = nodeId
+		}
+	}
+	if self.Nodes != nil {
+		result.Nodes = make(map[NodeId]*Node, len(self.Nodes))
+		for nodeId, node := range self.Nodes {
+			nodeCopy := &Node{
+				Id:    node.Id,
+				Size:  node.Size,
+				Units: copyUnits(node.Units),
+				state: result,
+			}
+			if node.Edges != nil {
+				nodeCopy.Edges = make(map[NodeId]Edge, len(node.Edges))
+				for dst, edge := range node.Edges {
+					edgeCopy := Edge{
+						Src: edge.Src,
+						Dst: edge.Dst,
+					}
+					if edge.Units != nil {
+						edgeCopy.Units = make([]map[PlayerId]int, len(edge.Units))
+						for index, spot := range edge.Units {
+							edgeCopy.Units[index] = copyUnits(spot)
+						}
+					}
+					nodeCopy.Edges[dst] = edgeCopy
+				}
+			}
+			result.Nodes[nodeId] = nodeCopy
+		}
+	}
+	if !history {
+		return
+	}
+	if self.Changes != nil {
+		result.Changes = make(map[NodeId]Changes, len(self.Changes))
+		for nodeId, changes := range self.Changes {
+			result.Changes[nodeId] = append(Changes(nil), changes...)
+		}
+	}
+	result.Events = copyEvents(self.Events)
+	result.Orders = copyOrders(self.Orders)
+	if self.Verdicts != nil {
+		result.Verdicts = make(map[PlayerId]Verdicts, len(self.Verdicts))
+		for playerId, verdicts := range self.Verdicts {
+			result.Verdicts[playerId] = append(Verdicts(nil), verdicts...)
+		}
+	}
+	return
+}
+
+func copyOrders(orderMap map[PlayerId]Orders) (result map[PlayerId]Orders) {
+	if orderMap == nil {
+		return nil
+	}
+	result = make(map[PlayerId]Orders, len(orderMap))
+	for playerId, orders := range orderMap {
+		result[playerId] = append(Orders(nil), orders...)
+	}
+	return
+}
+
+func copyEvents(events Events) (result Events) {
+	if events == nil {
+		return nil
+	}
+	result = make(Events, len(events))
+	for index, event := range events {
+		if event.UnitsDeparted != nil {
+			departed := *event.UnitsDeparted
+			result[index].UnitsDeparted = &departed
+		}
+		if event.UnitsArrived != nil {
+			arrived := *event.UnitsArrived
+			result[index].UnitsArrived = &arrived
+		}
+		if event.Battle != nil {
+			result[index].Battle = &Battle{
+				Node:         event.Battle.Node,
+				Participants: copyUnits(event.Battle.Participants),
+				Losses:       copyUnits(event.Battle.Losses),
+			}
+		}
+		if event.Growth != nil {
+			growth := *event.Growth
+			result[index].Growth = &growth
+		}
+		if event.Starvation != nil {
+			starvation := *event.Starvation
+			result[index].Starvation = &starvation
+		}
+		if event.PlayerEliminated != nil {
+			eliminated := *event.PlayerEliminated
+			result[index].PlayerEliminated = &eliminated
+		}
+	}
+	return
+}
+
+/*
+Copy returns a deep copy of self.
+
+Unlike Clone it doesn't encode and decode self, which makes it much faster, and it keeps the distance matrix of self since the copy has the same nodes and edges.
+*/
+func (self *State) Copy() *State {
+	return self.copy(true)
+}
+
+/*
+Outcome is what came out of simulating a turn.
+*/
+type Outcome struct {
+	// Winner is the only player left after the turn, if any.
+	Winner *PlayerId
+	// Units are the number of units of each player after the turn, on nodes and in transit.
+	Units map[PlayerId]int
+}
+
+/*
+Simulate returns the state after the next turn if orderMap is given, and its outcome, without changing self.
+
+The returned state gets its own copy of orderMap, and its Changes, Events and Verdicts describe the simulated turn. It is meant for AIs that search through possible futures, so it skips copying the Changes, Events, Orders and Verdicts of self.
+*/
+func (self *State) Simulate(orderMap map[PlayerId]Orders) (result *State, outcome Outcome) {
+	result = self.copy(false)
+	outcome.Winner = result.Next(discardLogger, copyOrders(orderMap))
+	outcome.Units = result.Units()
+	return
+}
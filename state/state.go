@@ -3,19 +3,63 @@ package state
 import (
 	"encoding/json"
 	"math/rand"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/zond/stockholm-ai/common"
 )
 
-const (
-	growthFactor     = 0.2
-	starvationFactor = 0.2
-)
-
 type NodeId string
 
+/*
+NodeIds is a sortable slice of node ids.
+*/
+type NodeIds []NodeId
+
+func (self NodeIds) Len() int {
+	return len(self)
+}
+
+func (self NodeIds) Less(i, j int) bool {
+	return self[i] < self[j]
+}
+
+func (self NodeIds) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
 type PlayerId string
 
+/*
+PlayerIds is a sortable slice of player ids.
+*/
+type PlayerIds []PlayerId
+
+func (self PlayerIds) Len() int {
+	return len(self)
+}
+
+func (self PlayerIds) Less(i, j int) bool {
+	return self[i] < self[j]
+}
+
+func (self PlayerIds) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+/*
+sortedPlayers returns the players in units in canonical (sorted) order.
+*/
+func sortedPlayers(units map[PlayerId]int) (result PlayerIds) {
+	result = make(PlayerIds, 0, len(units))
+	for playerId, _ := range units {
+		result = append(result, playerId)
+	}
+	sort.Sort(result)
+	return
+}
+
 type GameId string
 
 /*
@@ -48,6 +92,31 @@ type Node struct {
 	Units map[PlayerId]int
 	// Edges go from this node to others.
 	Edges map[NodeId]Edge
+
+	state *State
+}
+
+/*
+invalidateDistances makes the state self belongs to, if any, forget its distance matrix.
+*/
+func (self *Node) invalidateDistances() {
+	if self.state != nil {
+		self.state.distancesLock.Lock()
+		defer self.state.distancesLock.Unlock()
+		self.state.distances = nil
+	}
+}
+
+/*
+EdgeIds returns the destinations of the edges of this node in canonical (sorted) order.
+*/
+func (self *Node) EdgeIds() (result NodeIds) {
+	result = make(NodeIds, 0, len(self.Edges))
+	for nodeId, _ := range self.Edges {
+		result = append(result, nodeId)
+	}
+	sort.Sort(result)
+	return
 }
 
 func (self *Node) allReachable(c common.Logger, state *State) bool {
@@ -62,6 +131,8 @@ func (self *Node) allReachable(c common.Logger, state *State) bool {
 }
 
 func (self *Node) Connect(node *Node, edgeLength int) {
+	self.invalidateDistances()
+	node.invalidateDistances()
 	away := &Edge{
 		Src:   self.Id,
 		Dst:   node.Id,
@@ -78,14 +149,14 @@ func (self *Node) Connect(node *Node, edgeLength int) {
 	node.Edges[self.Id] = *here
 }
 
-func (self *Node) connectRandomly(c common.Logger, allNodes []*Node, state *State) {
-	minEdges := common.Norm(4, 2, 2, len(allNodes)-1)
-	self.connectMin(c, allNodes, state, minEdges)
+func (self *Node) connectRandomly(c common.Logger, r *rand.Rand, allNodes []*Node, state *State) {
+	minEdges := common.NormFrom(r, 4, 2, 2, len(allNodes)-1)
+	self.connectMin(c, r, allNodes, state, minEdges)
 }
 
-func (self *Node) connectMin(c common.Logger, allNodes []*Node, state *State, minEdges int) {
+func (self *Node) connectMin(c common.Logger, r *rand.Rand, allNodes []*Node, state *State, minEdges int) {
 	for len(self.Edges) < minEdges || !self.allReachable(c, state) {
-		perm := rand.Perm(len(allNodes))
+		perm := r.Perm(len(allNodes))
 		var randomNode *Node
 		for _, index := range perm {
 			suggested := allNodes[index]
@@ -96,7 +167,7 @@ func (self *Node) connectMin(c common.Logger, allNodes []*Node, state *State, mi
 				}
 			}
 		}
-		self.Connect(randomNode, common.Norm(3, 1, 1, 5))
+		self.Connect(randomNode, common.NormFrom(r, 3, 1, 1, 5))
 		minEdges--
 	}
 }
@@ -114,15 +185,19 @@ func NewNode(id NodeId, size int) *Node {
 }
 
 func (self *State) Add(n *Node) *State {
+	self.distancesLock.Lock()
+	defer self.distancesLock.Unlock()
 	self.Nodes[n.Id] = n
+	n.state = self
+	self.distances = nil
 	return self
 }
 
 /*
-RandomNode returns a random node without connections.
+RandomNode returns a random node without connections, drawing all random values from r.
 */
-func RandomNode() (result *Node) {
-	return NewNode(NodeId(common.RandomString(16)), common.Norm(50, 25, 10, 100))
+func RandomNode(r *rand.Rand) (result *Node) {
+	return NewNode(NodeId(common.RandomStringFrom(r, 16)), common.NormFrom(r, 50, 25, 10, 100))
 }
 
 /*
@@ -137,6 +212,8 @@ type Order struct {
 	Dst NodeId
 	// Units is the number of units to move.
 	Units int
+	// Resign makes the player give up, removing all its units. Src, Dst and Units are ignored, and all other orders of the player are rejected.
+	Resign bool `json:",omitempty"`
 }
 
 /*
@@ -144,8 +221,29 @@ Orders are given by AIs to move around their units.
 */
 type Orders []Order
 
+/*
+Resigns returns whether any of the orders is a Resign order.
+*/
+func (self Orders) Resigns() bool {
+	for _, order := range self {
+		if order.Resign {
+			return true
+		}
+	}
+	return false
+}
+
 type ChangeReason string
 
+const (
+	ChangeIncoming   ChangeReason = "Incoming"
+	ChangeOrders     ChangeReason = "Orders"
+	ChangeGrowth     ChangeReason = "Growth"
+	ChangeStarvation ChangeReason = "Starvation"
+	ChangeConflict   ChangeReason = "Conflict"
+	ChangeResigned   ChangeReason = "Resigned"
+)
+
 type Change struct {
 	Units    int
 	PlayerId PlayerId
@@ -158,12 +256,43 @@ type Changes []Change
 State completely describes a single turn of the game.
 */
 type State struct {
+	// Seed is the seed the random generator used to create the initial state was created with.
+	Seed int64
+	// Turn is the number of turns played to get to this state.
+	Turn int
+	// Rules are the rules this state is played by.
+	Rules Rules
+	// Starts are the nodes each player started the game at.
+	Starts map[PlayerId]NodeId
 	// Nodes are the nodes in the game.
 	Nodes map[NodeId]*Node
 	// Changes are the changes and reasons since last turn.
 	Changes map[NodeId]Changes
+	// Events are what happened since last turn, in the order it happened.
+	Events Events `json:",omitempty"`
+	// Eliminated contains the turn each eliminated player lost its last unit or resigned.
+	Eliminated map[PlayerId]int `json:",omitempty"`
 	// Orders from each player
 	Orders map[PlayerId]Orders
+	// Verdicts contain what happened to the orders from each player.
+	Verdicts map[PlayerId]Verdicts
+
+	distancesLock sync.Mutex
+	distances     *DistanceMatrix
+}
+
+/*
+NodeIds returns the ids of the nodes in this state in canonical (sorted) order.
+
+All iteration over nodes that affects the outcome of a turn happens in this order, to make Next deterministic.
+*/
+func (self *State) NodeIds() (result NodeIds) {
+	result = make(NodeIds, 0, len(self.Nodes))
+	for nodeId, _ := range self.Nodes {
+		result = append(result, nodeId)
+	}
+	sort.Sort(result)
+	return
 }
 
 func (self *State) Clone() (result *State) {
@@ -181,11 +310,13 @@ func (self *State) Clone() (result *State) {
 
 func (self *State) executeTransits(logger common.Logger) {
 	execution := []func(){}
-	for _, node := range self.Nodes {
-		for _, edge := range node.Edges {
+	for _, nodeId := range self.NodeIds() {
+		node := self.Nodes[nodeId]
+		for _, dst := range node.EdgeIds() {
+			edge := node.Edges[dst]
 			for index, units := range edge.Units {
-				for playerId, num := range units {
-					if num > 0 {
+				for _, playerId := range sortedPlayers(units) {
+					if num := units[playerId]; num > 0 {
 						numCpy := num
 						edgeCpy := edge
 						playerIdCpy := playerId
@@ -197,7 +328,15 @@ func (self *State) executeTransits(logger common.Logger) {
 								self.Changes[edgeCpy.Dst] = append(self.Changes[edgeCpy.Dst], Change{
 									Units:    numCpy,
 									PlayerId: playerIdCpy,
-									Reason:   ChangeReason("Incoming"),
+									Reason:   ChangeIncoming,
+								})
+								self.Events = append(self.Events, Event{
+									UnitsArrived: &UnitsArrived{
+										Src:    edgeCpy.Src,
+										Dst:    edgeCpy.Dst,
+										Player: playerIdCpy,
+										Units:  numCpy,
+									},
 								})
 							})
 						} else {
@@ -215,40 +354,90 @@ func (self *State) executeTransits(logger common.Logger) {
 	}
 }
 
-func (self *State) executeOrders(orderMap map[PlayerId]Orders) {
+/*
+executeOrders moves the units of the validated orders onto the edges, and removes the units of resigning players. It returns the players that resigned.
+*/
+func (self *State) executeOrders(orderMap map[PlayerId]Orders) (resigned map[PlayerId]bool) {
+	resigned = map[PlayerId]bool{}
 	execution := []func(){}
-	for playerId, orders := range orderMap {
-		for _, order := range orders {
-			if src, found := self.Nodes[order.Src]; found {
-				if edge, found := src.Edges[order.Dst]; found {
-					toMove := common.Max(0, common.Min(src.Units[playerId], order.Units))
-					src.Units[playerId] -= toMove
-					edgeCpy := edge
-					playerIdCpy := playerId
-					if toMove > 0 {
-						execution = append(execution, func() {
-							edgeCpy.Units[0][playerIdCpy] += toMove
-							self.Changes[edgeCpy.Src] = append(self.Changes[edgeCpy.Src], Change{
-								Units:    -toMove,
-								PlayerId: playerIdCpy,
-								Reason:   ChangeReason("Orders"),
-							})
-						})
-					}
-				}
+	playerIds := make(PlayerIds, 0, len(orderMap))
+	for playerId, _ := range orderMap {
+		playerIds = append(playerIds, playerId)
+	}
+	sort.Sort(playerIds)
+	for _, playerId := range playerIds {
+		verdicts := self.ValidateOrders(playerId, orderMap[playerId])
+		self.Verdicts[playerId] = verdicts
+		if orderMap[playerId].Resigns() {
+			resigned[playerId] = true
+			playerIdCpy := playerId
+			execution = append(execution, func() {
+				self.resign(playerIdCpy)
+			})
+			continue
+		}
+		for _, verdict := range verdicts {
+			if toMove := verdict.Units; toMove > 0 {
+				src := self.Nodes[verdict.Order.Src]
+				src.Units[playerId] -= toMove
+				edgeCpy := src.Edges[verdict.Order.Dst]
+				playerIdCpy := playerId
+				execution = append(execution, func() {
+					edgeCpy.Units[0][playerIdCpy] += toMove
+					self.Changes[edgeCpy.Src] = append(self.Changes[edgeCpy.Src], Change{
+						Units:    -toMove,
+						PlayerId: playerIdCpy,
+						Reason:   ChangeOrders,
+					})
+					self.Events = append(self.Events, Event{
+						UnitsDeparted: &UnitsDeparted{
+							Src:    edgeCpy.Src,
+							Dst:    edgeCpy.Dst,
+							Player: playerIdCpy,
+							Units:  toMove,
+						},
+					})
+				})
 			}
 		}
 	}
 	for _, exec := range execution {
 		exec()
 	}
+	return
+}
+
+/*
+resign removes all units of playerId, on nodes and in transit.
+*/
+func (self *State) resign(playerId PlayerId) {
+	for _, nodeId := range self.NodeIds() {
+		node := self.Nodes[nodeId]
+		if units := node.Units[playerId]; units > 0 {
+			node.Units[playerId] = 0
+			self.Changes[nodeId] = append(self.Changes[nodeId], Change{
+				Units:    -units,
+				PlayerId: playerId,
+				Reason:   ChangeResigned,
+			})
+		}
+		for _, edge := range node.Edges {
+			for _, spot := range edge.Units {
+				if spot[playerId] > 0 {
+					spot[playerId] = 0
+				}
+			}
+		}
+	}
 }
 
 // executeGrowth will increas the number of units in nodes with an owner, and decrease the number of units in nodes with more units than size.
 func (self *State) executeGrowth(c common.Logger) {
+	rules := self.Rules.OrDefault()
 	execution := []func(){}
-	// for each node
-	for _, node := range self.Nodes {
+	// for each node, in canonical order
+	for _, nodeId := range self.NodeIds() {
+		node := self.Nodes[nodeId]
 		// calculate total
 		total := 0
 		for _, units := range node.Units {
@@ -256,8 +445,8 @@ func (self *State) executeGrowth(c common.Logger) {
 		}
 		// build a slice of players with units
 		players := make([]PlayerId, 0, len(node.Units))
-		for playerId, units := range node.Units {
-			if units > 0 {
+		for _, playerId := range sortedPlayers(node.Units) {
+			if node.Units[playerId] > 0 {
 				players = append(players, playerId)
 			}
 		}
@@ -266,26 +455,34 @@ func (self *State) executeGrowth(c common.Logger) {
 			playerId := players[0]
 			units := node.Units[playerId]
 			nodeCpy := node
-			newSum := common.Min(node.Size, int(1+float64(units)*(1.0+(growthFactor*(float64(node.Size-total)/float64(node.Size))))))
+			newSum := common.Min(node.Size, int(1+float64(units)*(1.0+(rules.GrowthFactor*(float64(node.Size-total)/float64(node.Size))))))
 			if newSum > units {
 				execution = append(execution, func() {
 					nodeCpy.Units[playerId] = newSum
 					self.Changes[nodeCpy.Id] = append(self.Changes[nodeCpy.Id], Change{
 						Units:    newSum - units,
 						PlayerId: playerId,
-						Reason:   ChangeReason("Growth"),
+						Reason:   ChangeGrowth,
+					})
+					self.Events = append(self.Events, Event{
+						Growth: &Growth{
+							Node:   nodeCpy.Id,
+							Player: playerId,
+							Units:  newSum - units,
+						},
 					})
 				})
 			}
 			// else if we have more units than size
 		} else if total > node.Size {
-			// for eachplayer
-			for playerId, units := range node.Units {
+			// for each player, in canonical order
+			for _, playerId := range sortedPlayers(node.Units) {
+				units := node.Units[playerId]
 				// with units
 				if units > 0 {
 					playerIdCpy := playerId
 					nodeCpy := node
-					newSum := common.Max(0, int(float64(units)/(1.0+(starvationFactor*(float64(units)/float64(node.Size)))))-1)
+					newSum := common.Max(0, int(float64(units)/(1.0+(rules.StarvationFactor*(float64(units)/float64(node.Size)))))-1)
 					if newSum < units {
 						oldSum := units
 						execution = append(execution, func() {
@@ -293,7 +490,14 @@ func (self *State) executeGrowth(c common.Logger) {
 							self.Changes[nodeCpy.Id] = append(self.Changes[nodeCpy.Id], Change{
 								Units:    newSum - oldSum,
 								PlayerId: playerIdCpy,
-								Reason:   ChangeReason("Starvation"),
+								Reason:   ChangeStarvation,
+							})
+							self.Events = append(self.Events, Event{
+								Starvation: &Starvation{
+									Node:   nodeCpy.Id,
+									Player: playerIdCpy,
+									Units:  oldSum - newSum,
+								},
 							})
 						})
 					}
@@ -307,37 +511,75 @@ func (self *State) executeGrowth(c common.Logger) {
 }
 
 func (self *State) executeConflicts(l common.Logger) {
+	rules := self.Rules.OrDefault()
 	execution := []func(){}
-	for _, node := range self.Nodes {
+	for _, nodeId := range self.NodeIds() {
+		node := self.Nodes[nodeId]
 		total := 0
 		for _, units := range node.Units {
 			total += units
 		}
-		for playerId, units := range node.Units {
+		battle := &Battle{
+			Node:         nodeId,
+			Participants: map[PlayerId]int{},
+			Losses:       map[PlayerId]int{},
+		}
+		for _, playerId := range sortedPlayers(node.Units) {
+			units := node.Units[playerId]
 			enemies := total - units
 			if units > 0 && enemies > 0 {
-				newSum := common.Max(0, common.Min(units-1, int(float64(units)-(float64(enemies)/5.0))))
+				battle.Participants[playerId] = units
+				newSum := common.Max(0, common.Min(units-1, int(float64(units)-(float64(enemies)/rules.ConflictDivisor))))
 				playerIdCpy := playerId
 				nodeCpy := node
 				if newSum < units {
 					oldSum := units
+					battle.Losses[playerId] = oldSum - newSum
 					execution = append(execution, func() {
 						nodeCpy.Units[playerIdCpy] = newSum
 						self.Changes[nodeCpy.Id] = append(self.Changes[nodeCpy.Id], Change{
 							Units:    newSum - oldSum,
 							PlayerId: playerIdCpy,
-							Reason:   ChangeReason("Conflict"),
+							Reason:   ChangeConflict,
 						})
 					})
 				}
 			}
 		}
+		if len(battle.Participants) > 1 {
+			execution = append(execution, func() {
+				self.Events = append(self.Events, Event{
+					Battle: battle,
+				})
+			})
+		}
 	}
 	for _, exec := range execution {
 		exec()
 	}
 }
 
+/*
+executeEliminations remembers that each player that had units before the turn, but has none left, was eliminated this turn, and adds a PlayerEliminated event for it.
+*/
+func (self *State) executeEliminations(before map[PlayerId]int, resigned map[PlayerId]bool) {
+	after := self.Units()
+	for _, playerId := range sortedPlayers(before) {
+		if before[playerId] > 0 && after[playerId] == 0 {
+			if self.Eliminated == nil {
+				self.Eliminated = map[PlayerId]int{}
+			}
+			self.Eliminated[playerId] = self.Turn
+			self.Events = append(self.Events, Event{
+				PlayerEliminated: &PlayerEliminated{
+					Player:   playerId,
+					Resigned: resigned[playerId],
+				},
+			})
+		}
+	}
+}
+
 func (self *State) onlyPlayerLeft(c common.Logger) *PlayerId {
 	players := map[PlayerId]bool{}
 	for _, node := range self.Nodes {
@@ -368,14 +610,21 @@ func (self *State) onlyPlayerLeft(c common.Logger) *PlayerId {
 
 /*
 Next changes this state into the next state, subject to the provided orders.
+
+Nodes, edges and players are always processed in canonical (sorted) order, so identical states and orders always produce identical next states, including the order of the Changes and Events.
 */
 func (self *State) Next(c common.Logger, orderMap map[PlayerId]Orders) (winner *PlayerId) {
 	self.Changes = map[NodeId]Changes{}
+	self.Events = nil
 	self.Orders = orderMap
+	self.Verdicts = map[PlayerId]Verdicts{}
+	self.Turn += 1
+	before := self.Units()
 	self.executeTransits(c)
-	self.executeOrders(orderMap)
+	resigned := self.executeOrders(orderMap)
 	self.executeGrowth(c)
 	self.executeConflicts(c)
+	self.executeEliminations(before, resigned)
 	winner = self.onlyPlayerLeft(c)
 	return
 }
@@ -455,42 +704,31 @@ func NewState() *State {
 	return &State{
 		Nodes:   map[NodeId]*Node{},
 		Changes: map[NodeId]Changes{},
+		Starts:  map[PlayerId]NodeId{},
 	}
 }
 
 /*
-RandomState creates a random state for the provided players.
+RandomState creates a random state for the provided players, using a seed based on the current time.
 */
-func RandomState(c common.Logger, players []PlayerId) (result *State) {
-	result = NewState()
-	size := common.Norm(len(players)*6, len(players), len(players)*4, len(players)*10)
-	allNodes := make([]*Node, 0, size)
-	for i := 0; i < size; i++ {
-		node := RandomNode()
-		result.Nodes[node.Id] = node
-		allNodes = append(allNodes, node)
-	}
-	for _, node := range allNodes {
-		node.connectRandomly(c, allNodes, result)
-	}
-	perm := rand.Perm(len(allNodes))
-	startNodes := make([]*Node, len(players))
-	maxEdges := 0
-	smallest := 100
-	for index, playerId := range players {
-		node := allNodes[perm[index]]
-		node.Units[playerId] = 10
-		if smallest > node.Size {
-			smallest = node.Size
-		}
-		if len(node.Edges) > maxEdges {
-			maxEdges = len(node.Edges)
-		}
-		startNodes[index] = node
-	}
-	for _, node := range startNodes {
-		node.Size = smallest
-		node.connectMin(c, allNodes, result, maxEdges)
-	}
-	return
+func RandomState(c common.Logger, players []PlayerId) (result *State, err error) {
+	return RandomStateWithSeed(c, time.Now().UnixNano(), players)
+}
+
+/*
+RandomStateWithSeed creates a random state for the provided players.
+
+All random decisions are drawn from a generator created with seed, so the same seed and players will always produce the same state.
+*/
+func RandomStateWithSeed(c common.Logger, seed int64, players []PlayerId) (result *State, err error) {
+	return RandomStateWithRules(c, seed, DefaultRules(), players)
+}
+
+/*
+RandomStateWithRules is like RandomStateWithSeed, but creates a state played by rules.
+
+It returns an error if there are no players.
+*/
+func RandomStateWithRules(c common.Logger, seed int64, rules Rules, players []PlayerId) (result *State, err error) {
+	return GenerateState(c, RandomGenerator{}, seed, rules, players)
 }
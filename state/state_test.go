@@ -1,10 +1,20 @@
 package state
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
 	"testing"
+
+	"github.com/zond/stockholm-ai/common"
 )
 
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
 var a = NodeId("a")
 var b = NodeId("b")
 var c = NodeId("c")
@@ -60,3 +70,683 @@ func TestPath(t *testing.T) {
 	assertPath(t, s, a, f, b, no, f, no, no)
 	assertPath(t, s, f, g, b, no, no, g, no)
 }
+
+func TestRandomStateWithSeed(t *testing.T) {
+	players := []PlayerId{"p1", "p2", "p3"}
+	s1, err := RandomStateWithSeed(nil, 42, players)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := RandomStateWithSeed(nil, 42, players)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s1, s2) {
+		t.Fatalf("Wanted identical states from identical seeds, but got %#v and %#v", s1, s2)
+	}
+	if s3, err := RandomStateWithSeed(nil, 43, players); err != nil || reflect.DeepEqual(s1.Nodes, s3.Nodes) {
+		t.Fatalf("Wanted different states from different seeds, but got %#v twice and %v", s1, err)
+	}
+	if _, err := RandomStateWithSeed(nil, 42, nil); err == nil {
+		t.Fatalf("Wanted an error without players")
+	}
+}
+
+/*
+scriptedOrders makes every player send half the units of every node it occupies along the edge to the node with the lowest id, to get a repeatable but non trivial game.
+*/
+func scriptedOrders(s *State) (result map[PlayerId]Orders) {
+	result = map[PlayerId]Orders{}
+	for _, nodeId := range s.NodeIds() {
+		node := s.Nodes[nodeId]
+		for _, playerId := range sortedPlayers(node.Units) {
+			if units := node.Units[playerId]; units > 1 {
+				result[playerId] = append(result[playerId], Order{
+					Src:   nodeId,
+					Dst:   node.EdgeIds()[0],
+					Units: units / 2,
+				})
+			}
+		}
+	}
+	return
+}
+
+func playScripted(t *testing.T, turns int) []byte {
+	s := testState()
+	s.Nodes[a].Units["p1"] = 50
+	s.Nodes[e].Units["p2"] = 50
+	s.Nodes[g].Units["p3"] = 20
+	states := []*State{}
+	for i := 0; i < turns; i++ {
+		s.Next(nil, scriptedOrders(s))
+		states = append(states, s.Clone())
+	}
+	b, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNextGolden(t *testing.T) {
+	golden := filepath.Join("testdata", "next.golden")
+	found := playScripted(t, 20)
+	for i := 0; i < 10; i++ {
+		if again := playScripted(t, 20); !bytes.Equal(found, again) {
+			t.Fatalf("Wanted identical output from identical input, but run %v differed", i)
+		}
+	}
+	if *update {
+		if err := ioutil.WriteFile(golden, found, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(found, want) {
+		t.Fatalf("Wanted output to match %v, run with -update if the change is intended", golden)
+	}
+}
+
+func TestRules(t *testing.T) {
+	if err := DefaultRules().Validate(); err != nil {
+		t.Fatalf("Wanted default rules to be valid, but got %v", err)
+	}
+	if found := (Rules{}).OrDefault(); found != DefaultRules() {
+		t.Fatalf("Wanted zero rules to mean %+v, but got %+v", DefaultRules(), found)
+	}
+	rules := DefaultRules()
+	rules.StartUnits = 33
+	s, err := RandomStateWithRules(nil, 1, rules, []PlayerId{"p1", "p2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, playerId := range []PlayerId{"p1", "p2"} {
+		found := 0
+		for _, node := range s.Nodes {
+			found += node.Units[playerId]
+		}
+		if found != 33 {
+			t.Fatalf("Wanted %v to start with 33 units, but got %v", playerId, found)
+		}
+	}
+	rules.ConflictDivisor = 0
+	if err := rules.Validate(); err == nil {
+		t.Fatalf("Wanted a zero ConflictDivisor to be invalid")
+	}
+}
+
+func TestValidateOrders(t *testing.T) {
+	s := testState()
+	s.Nodes[a].Units["p1"] = 10
+	s.Nodes[b].Units["p2"] = 10
+	found := s.ValidateOrders("p1", Orders{
+		{Src: a, Dst: b, Units: 4},
+		{Src: h, Dst: a, Units: 1},
+		{Src: a, Dst: c, Units: 1},
+		{Src: a, Dst: d, Units: -1},
+		{Src: a, Dst: b, Units: 1},
+		{Src: b, Dst: a, Units: 1},
+		{Src: a, Dst: d, Units: 8},
+		{Src: a, Dst: g, Units: 1},
+	})
+	statuses := []VerdictStatus{}
+	units := []int{}
+	for _, verdict := range found {
+		statuses = append(statuses, verdict.Status)
+		units = append(units, verdict.Units)
+	}
+	wantStatuses := []VerdictStatus{VerdictAccepted, VerdictRejected, VerdictRejected, VerdictRejected, VerdictRejected, VerdictRejected, VerdictClamped, VerdictRejected}
+	if !reflect.DeepEqual(statuses, wantStatuses) {
+		t.Fatalf("Wanted %v, but got %v", wantStatuses, statuses)
+	}
+	if wantUnits := []int{4, 0, 0, 0, 0, 0, 6, 0}; !reflect.DeepEqual(units, wantUnits) {
+		t.Fatalf("Wanted %v, but got %v", wantUnits, units)
+	}
+	s.Next(nil, map[PlayerId]Orders{
+		"p1": Orders{{Src: a, Dst: b, Units: 4}, {Src: a, Dst: b, Units: 4}},
+	})
+	if found := s.Nodes[a].Edges[b].Units[0]["p1"]; found != 4 {
+		t.Fatalf("Wanted duplicate order to be ignored, but got %v units in transit", found)
+	}
+	if found := len(s.Verdicts["p1"].Rejected()); found != 1 {
+		t.Fatalf("Wanted one rejected verdict recorded, but got %v", found)
+	}
+}
+
+func TestVisibleTo(t *testing.T) {
+	s := testState()
+	s.Nodes[a].Units["p1"] = 10
+	s.Nodes[c].Units["p2"] = 10
+	s.Nodes[d].Edges[e].Units[1]["p1"] = 5
+	if found := s.VisibleTo("p1", DefaultRules()); found != s {
+		t.Fatalf("Wanted the full state without fog of war")
+	}
+	rules := DefaultRules()
+	rules.FogOfWar = true
+	found := s.VisibleTo("p1", rules)
+	nodes := found.NodeIds()
+	if want := (NodeIds{a, b, d, e, g}); !reflect.DeepEqual(nodes, want) {
+		t.Fatalf("Wanted %v to be visible, but got %v", want, nodes)
+	}
+	if edges := found.Nodes[b].EdgeIds(); !reflect.DeepEqual(edges, NodeIds{a}) {
+		t.Fatalf("Wanted only the edge back to a from b, but got %v", edges)
+	}
+	if edges := found.Nodes[d].EdgeIds(); !reflect.DeepEqual(edges, NodeIds{a, e}) {
+		t.Fatalf("Wanted the edges to a and e from d, but got %v", edges)
+	}
+	if len(s.Nodes) != 8 || len(s.Nodes[b].Edges) != 4 {
+		t.Fatalf("Wanted the original state to be untouched")
+	}
+	s.Seed = 42
+	s.Starts = map[PlayerId]NodeId{"p1": a, "p2": c}
+	found = s.VisibleTo("p1", rules)
+	if found.Seed != 0 || !reflect.DeepEqual(found.Starts, map[PlayerId]NodeId{"p1": a}) {
+		t.Fatalf("Wanted neither the seed nor the start of p2 to be visible, but got %v and %v", found.Seed, found.Starts)
+	}
+	s.Eliminated = map[PlayerId]int{"p1": 3, "p3": 2}
+	s.Events = Events{
+		Event{PlayerEliminated: &PlayerEliminated{Player: "p3"}},
+		Event{PlayerEliminated: &PlayerEliminated{Player: "p1", Resigned: true}},
+	}
+	found = s.VisibleTo("p1", rules)
+	if !reflect.DeepEqual(found.Eliminated, map[PlayerId]int{"p1": 3}) || !reflect.DeepEqual(found.Events, Events{s.Events[1]}) {
+		t.Fatalf("Wanted only the elimination of p1 to be visible, but got %v and %+v", found.Eliminated, found.Events)
+	}
+}
+
+func TestMapGenerators(t *testing.T) {
+	for _, name := range MapGeneratorNames() {
+		generator, err := GetMapGenerator(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for numPlayers := 1; numPlayers < 6; numPlayers++ {
+			players := []PlayerId{}
+			for i := 0; i < numPlayers; i++ {
+				players = append(players, PlayerId(fmt.Sprintf("p%v", i)))
+			}
+			s, err := GenerateState(nil, generator, 7, DefaultRules(), players)
+			if err != nil {
+				t.Fatalf("Wanted %v to generate a map for %v players, but got %v", name, numPlayers, err)
+			}
+			if again, _ := GenerateState(nil, generator, 7, DefaultRules(), players); !reflect.DeepEqual(s, again) {
+				t.Fatalf("Wanted %v to be deterministic for %v players", name, numPlayers)
+			}
+			for _, node := range s.Nodes {
+				if !node.allReachable(nil, s) {
+					t.Fatalf("Wanted all nodes generated by %v for %v players to be reachable from %v", name, numPlayers, node.Id)
+				}
+			}
+			for _, playerId := range players {
+				found := 0
+				for _, node := range s.Nodes {
+					found += node.Units[playerId]
+				}
+				if found != DefaultRules().StartUnits {
+					t.Fatalf("Wanted %v to start with %v units on maps generated by %v, but got %v", playerId, DefaultRules().StartUnits, name, found)
+				}
+			}
+		}
+	}
+	if _, err := GetMapGenerator("nonexistent"); err == nil {
+		t.Fatalf("Wanted an error for an unknown generator")
+	}
+	for _, name := range MapGeneratorNames() {
+		generator, _ := GetMapGenerator(name)
+		if _, err := GenerateState(nil, generator, 7, DefaultRules(), nil); err == nil {
+			t.Fatalf("Wanted an error when generating a %v map without players", name)
+		}
+	}
+}
+
+func TestContinentsGenerator(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		players := []PlayerId{"p1", "p2", "p3", "p4"}
+		s, err := GenerateState(nil, ContinentsGenerator{}, seed, DefaultRules(), players)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// continents are the nodes connected by short edges
+		continent := map[NodeId]int{}
+		for _, nodeId := range s.NodeIds() {
+			if _, found := continent[nodeId]; found {
+				continue
+			}
+			queue := []NodeId{nodeId}
+			continent[nodeId] = len(continent)
+			for len(queue) > 0 {
+				for dst, edge := range s.Nodes[queue[0]].Edges {
+					if _, found := continent[dst]; !found && len(edge.Units) <= 2 {
+						continent[dst] = continent[nodeId]
+						queue = append(queue, dst)
+					}
+				}
+				queue = queue[1:]
+			}
+		}
+		for _, node := range s.Nodes {
+			for dst, edge := range node.Edges {
+				if len(edge.Units) > 2 && continent[node.Id] == continent[dst] {
+					t.Fatalf("Wanted long edges to join continents, but %v and %v are on the same one with seed %v", node.Id, dst, seed)
+				}
+			}
+		}
+	}
+}
+
+func TestFairGenerator(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		for numPlayers := 1; numPlayers < 7; numPlayers++ {
+			players := []PlayerId{}
+			for i := 0; i < numPlayers; i++ {
+				players = append(players, PlayerId(fmt.Sprintf("p%v", i)))
+			}
+			s, err := GenerateState(nil, FairGenerator{}, seed, DefaultRules(), players)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report := s.Fairness(); !report.Fair() {
+				t.Fatalf("Wanted a fair map for %v players with seed %v, but got %+v", numPlayers, seed, report)
+			}
+		}
+	}
+	s := testState()
+	s.Starts = map[PlayerId]NodeId{"p1": a, "p2": e}
+	if report := s.Fairness(); report.Fair() {
+		t.Fatalf("Wanted starts at a and e to be unfair, but got %+v", report)
+	}
+	disconnected := NewState()
+	disconnected.Add(NewNode(a, 10)).Add(NewNode(b, 10)).Add(NewNode(c, 10)).Add(NewNode(d, 10))
+	disconnected.Nodes[a].Connect(disconnected.Nodes[b], 1)
+	disconnected.Nodes[c].Connect(disconnected.Nodes[d], 1)
+	disconnected.Starts = map[PlayerId]NodeId{"p1": a, "p2": c}
+	report := disconnected.Fairness()
+	if want := (PlayerFairness{Start: a, NodeDistance: 2, Unreachable: 2, Territory: 2, TerritorySize: 20}); report["p1"] != want {
+		t.Fatalf("Wanted %+v, but got %+v", want, report["p1"])
+	}
+	if !report.Fair() {
+		t.Fatalf("Wanted two identical islands to be fair, but got %+v", report)
+	}
+	if err := s.VerifyAutomorphism(map[NodeId]NodeId{a: b, b: a, c: c, d: d, e: e, f: f, g: g, h: h}); err == nil {
+		t.Fatalf("Wanted swapping a and b to not be an automorphism")
+	}
+}
+
+func TestMaps(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "maps", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("Wanted some curated maps")
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := LoadMap(bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatalf("Wanted %v to be a valid map, but got %v", file, err)
+		}
+		s, err := m.State(DefaultRules(), []PlayerId{"p1", "p2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := SaveMap(buf, s.Map(m.Name)); err != nil {
+			t.Fatal(err)
+		}
+		again, err := LoadMap(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s2, err := again.State(DefaultRules(), []PlayerId{"p1", "p2"}); err != nil || !reflect.DeepEqual(s, s2) {
+			t.Fatalf("Wanted %v to survive export and import, but got %v", file, err)
+		}
+	}
+	m := &Map{
+		Name:   "broken",
+		Nodes:  []MapNode{{Id: a, Size: 10}, {Id: b, Size: 10}},
+		Starts: []NodeId{a},
+	}
+	if _, err := m.State(DefaultRules(), []PlayerId{"p1"}); err == nil {
+		t.Fatalf("Wanted an unconnected map to be invalid")
+	}
+	m.Edges = []MapEdge{{Src: a, Dst: b, Length: 1}}
+	if _, err := m.State(DefaultRules(), []PlayerId{"p1", "p2"}); err == nil {
+		t.Fatalf("Wanted too many players for the start slots to be an error")
+	}
+}
+
+func TestRanking(t *testing.T) {
+	s := testState()
+	s.Nodes[a].Units["p1"] = 10
+	s.Nodes[b].Units["p2"] = 5
+	s.Nodes[a].Edges[d].Units[1]["p2"] = 5
+	s.Nodes[c].Units["p3"] = 10
+	s.Nodes[c].Units["p4"] = 1
+	found := s.Ranking([]PlayerId{"p1", "p2", "p3", "p4", "p5", "p6"}, map[PlayerId]int{"p5": 7, "p6": 3})
+	ranks := map[PlayerId]int{}
+	for _, placement := range found {
+		ranks[placement.Player] = placement.Rank
+	}
+	if want := map[PlayerId]int{"p1": 1, "p2": 1, "p3": 3, "p4": 4, "p5": 5, "p6": 6}; !reflect.DeepEqual(ranks, want) {
+		t.Fatalf("Wanted %v, but got %v", want, ranks)
+	}
+	if winners := found.Winners(); !reflect.DeepEqual(winners, PlayerIds{"p1", "p2"}) {
+		t.Fatalf("Wanted a draw between p1 and p2, but got %v", winners)
+	}
+	s.Nodes[b].Units["p2"] = 4
+	if winners := s.Ranking([]PlayerId{"p1", "p2"}, nil).Winners(); !reflect.DeepEqual(winners, PlayerIds{"p1"}) {
+		t.Fatalf("Wanted p1 to win, but got %v", winners)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	players := []PlayerId{"p1", "p2"}
+	generator, _ := GetMapGenerator("")
+	s, err := GenerateState(nil, generator, 3, DefaultRules(), players)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := []*State{s.Clone()}
+	for i := 0; i < 10; i++ {
+		s.Next(nil, scriptedOrders(s))
+		states = append(states, s.Clone())
+	}
+	replay := NewReplay(states)
+	replay.Players = players
+	b, err := json.Marshal(replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Replay{}
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatal(err)
+	}
+	found, err := decoded.States(nil)
+	if err != nil {
+		t.Fatalf("Wanted a valid replay, but got %v", err)
+	}
+	if !sameJSON(found[len(found)-1], s) {
+		t.Fatalf("Wanted the re-simulated final state to be the played one")
+	}
+	decoded.Placements = s.Ranking(players, nil)
+	if err := decoded.Validate(nil); err == nil {
+		t.Fatalf("Wanted placements of an unfinished game to be invalid")
+	}
+	decoded.Placements = nil
+	decoded.Seed += 1
+	if err := decoded.Validate(nil); err == nil {
+		t.Fatalf("Wanted an initial state not matching the seed to be invalid")
+	}
+	decoded.Seed -= 1
+	for _, turn := range decoded.Turns {
+		if len(turn.Orders["p1"]) > 0 {
+			turn.Orders["p1"][0].Units += 1
+			break
+		}
+	}
+	if err := decoded.Validate(nil); err == nil {
+		t.Fatalf("Wanted tampered orders to be invalid")
+	}
+	crafted := NewReplay([]*State{testState()})
+	crafted.Map = "crafted"
+	crafted.Players = players
+	crafted.Turns = []ReplayTurn{ReplayTurn{Ordinal: 1}}
+	crafted.Initial.Nodes[a].Edges[no] = Edge{Src: a, Dst: no, Units: []map[PlayerId]int{map[PlayerId]int{"p1": 1}}}
+	if err := crafted.Validate(nil); err == nil {
+		t.Fatalf("Wanted an edge to an unknown node to be invalid")
+	}
+	delete(crafted.Initial.Nodes[a].Edges, no)
+	crafted.Initial.Nodes[c].Units = nil
+	if err := crafted.Validate(nil); err == nil {
+		t.Fatalf("Wanted a node without units to be invalid")
+	}
+	crafted.Initial.Nodes[c].Units = map[PlayerId]int{}
+	if err := crafted.Validate(nil); err != nil {
+		t.Fatalf("Wanted a well formed crafted replay to be valid, but got %v", err)
+	}
+	crafted.Rules.MaxTurns = 1000
+	if err := crafted.Validate(nil); err == nil {
+		t.Fatalf("Wanted a replay claiming other rules than its initial state to be invalid")
+	}
+}
+
+func TestGraph(t *testing.T) {
+	s := testState()
+	distances := s.Distances()
+	for _, src := range s.NodeIds() {
+		for _, dst := range s.NodeIds() {
+			path := s.Path(src, dst, nil)
+			distance, found := distances.Distance(src, dst)
+			if src != dst && (found != (path != nil) || distance != len(path)) {
+				t.Fatalf("Wanted distance from %v to %v to be the length of %#v, but got %v, %v", src, dst, path, distance, found)
+			}
+		}
+	}
+	if next := distances.Next(a, g); next != b {
+		t.Fatalf("Wanted to go from a to g through b, but got %#v", next)
+	}
+	routes := s.KShortestRoutes(a, c, 3, nil)
+	expected := []Route{
+		Route{Nodes: []NodeId{a, b, c}, Cost: 4},
+		Route{Nodes: []NodeId{a, d, c}, Cost: 8},
+		Route{Nodes: []NodeId{a, b, f, c}, Cost: 9},
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("Wanted %+v, but got %+v", expected, routes)
+	}
+	s.Nodes[c].Units["p2"] = 100
+	if route, _ := s.ShortestPaths(a, s.EnemyCost("p1", 1)).Route(e); !reflect.DeepEqual(route.Nodes, []NodeId{a, d, e}) {
+		t.Fatalf("Wanted to avoid the enemies at c, but got %+v", route)
+	}
+	s.Nodes[a].Units["p1"] = 10
+	s.Nodes[b].Units["p1"] = 10
+	s.Nodes[g].Units["p1"] = 10
+	if frontier := s.Frontier("p1"); !reflect.DeepEqual(frontier, NodeIds{a, b}) {
+		t.Fatalf("Wanted a and b to be the frontier, but got %v", frontier)
+	}
+	if chokepoints := s.Chokepoints(); len(chokepoints) != 0 {
+		t.Fatalf("Wanted no chokepoints, but got %v", chokepoints)
+	}
+	s.Nodes[e].Connect(s.Nodes[h], 1)
+	if chokepoints := s.Chokepoints(); !reflect.DeepEqual(chokepoints, NodeIds{e}) {
+		t.Fatalf("Wanted e to be the only chokepoint, but got %v", chokepoints)
+	}
+	if distance, found := s.Distances().Distance(a, h); !found || distance != 8 {
+		t.Fatalf("Wanted connecting e and h to update the distances, but got %v, %v", distance, found)
+	}
+	decoded := s.Clone()
+	done := make(chan *DistanceMatrix)
+	for i := 0; i < 4; i++ {
+		go func() {
+			done <- decoded.Distances()
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	decoded.Nodes[a].Connect(decoded.Nodes[h], 1)
+	if distance, _ := decoded.Distances().Distance(a, h); distance != 2 {
+		t.Fatalf("Wanted connecting a and h in a decoded state to update the distances, but got %v", distance)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	s := testState()
+	s.Nodes[a].Units["p1"] = 10
+	s.Nodes[d].Edges[e].Units[0]["p2"] = 7
+	if forecast := s.Forecast(-1); len(forecast) != 0 {
+		t.Fatalf("Wanted no projections for a negative horizon, but got %v", len(forecast))
+	}
+	forecast := s.Forecast(4)
+	if len(forecast) != 4 {
+		t.Fatalf("Wanted 4 projections, but got %v", len(forecast))
+	}
+	if arrivals := forecast.EnemyArrivals("p1", e, 2); arrivals != 0 {
+		t.Fatalf("Wanted no enemies at e within 2 turns, but got %v", arrivals)
+	}
+	if arrivals := forecast.EnemyArrivals("p1", e, 3); arrivals != 7 {
+		t.Fatalf("Wanted 7 enemies at e within 3 turns, but got %v", arrivals)
+	}
+	if s.Nodes[a].Units["p1"] != 10 || s.Nodes[d].Edges[e].Units[0]["p2"] != 7 {
+		t.Fatalf("Wanted the forecast to leave the state alone")
+	}
+	for turn := 1; turn <= len(forecast); turn++ {
+		s.Next(nil, nil)
+		for _, nodeId := range s.NodeIds() {
+			expected := forecast.Units(nodeId, turn)
+			for playerId, units := range s.Nodes[nodeId].Units {
+				if units != expected[playerId] {
+					t.Fatalf("Wanted %v to have %v units at %v after %v turns, but got %v", playerId, units, nodeId, turn, expected[playerId])
+				}
+			}
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	s := testState()
+	s.Nodes[a].Units["p1"] = 50
+	s.Nodes[e].Units["p2"] = 50
+	for i := 0; i < 3; i++ {
+		s.Next(nil, scriptedOrders(s))
+	}
+	if cpy := s.Copy(); !reflect.DeepEqual(cpy, s) {
+		t.Fatalf("Wanted %+v, but got %+v", s, cpy)
+	}
+	before := s.Clone()
+	orders := scriptedOrders(s)
+	expected := s.Clone()
+	winner := expected.Next(nil, orders)
+	result, outcome := s.Simulate(orders)
+	if !sameJSON(result, expected) {
+		t.Fatalf("Wanted %+v, but got %+v", expected, result)
+	}
+	if !reflect.DeepEqual(outcome.Winner, winner) || !reflect.DeepEqual(outcome.Units, expected.Units()) {
+		t.Fatalf("Wanted winner %v and units %v, but got %+v", winner, expected.Units(), outcome)
+	}
+	if !sameJSON(s, before) {
+		t.Fatalf("Wanted Simulate to leave the state alone")
+	}
+	s.Events = append(s.Events, Event{Battle: &Battle{
+		Node:         a,
+		Participants: map[PlayerId]int{"p1": 3, "p2": 2},
+		Losses:       map[PlayerId]int{"p1": 1, "p2": 1},
+	}})
+	cpy := s.Copy()
+	battle := cpy.Events[len(cpy.Events)-1].Battle
+	battle.Node = b
+	battle.Participants["p1"] = 30
+	battle.Losses["p2"] = 10
+	if original := s.Events[len(s.Events)-1].Battle; original.Node != a || original.Participants["p1"] != 3 || original.Losses["p2"] != 1 {
+		t.Fatalf("Wanted changing a copied battle to leave the original alone, but got %+v", original)
+	}
+}
+
+func benchmarkState() *State {
+	s, err := RandomStateWithSeed(nil, 42, []PlayerId{"p1", "p2", "p3", "p4"})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func BenchmarkClone(b *testing.B) {
+	s := benchmarkState()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.Clone()
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	s := benchmarkState()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.Copy()
+	}
+}
+
+func BenchmarkSimulate(b *testing.B) {
+	s := benchmarkState()
+	orders := scriptedOrders(s)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.Simulate(orders)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := testState()
+	s.Nodes[a].Units["p1"] = 20
+	s.Nodes[b].Units["p1"] = 2
+	s.Nodes[b].Units["p2"] = 1
+	s.Nodes[e].Units["p3"] = 10
+	s.Next(nil, map[PlayerId]Orders{
+		"p1": Orders{Order{Src: a, Dst: d, Units: 5}},
+	})
+	expected := Events{
+		Event{UnitsDeparted: &UnitsDeparted{Src: a, Dst: d, Player: "p1", Units: 5}},
+		Event{Growth: &Growth{Node: a, Player: "p1", Units: 3}},
+		Event{Growth: &Growth{Node: e, Player: "p3", Units: 2}},
+		Event{Battle: &Battle{Node: b, Participants: map[PlayerId]int{"p1": 2, "p2": 1}, Losses: map[PlayerId]int{"p1": 1, "p2": 1}}},
+		Event{PlayerEliminated: &PlayerEliminated{Player: "p2"}},
+	}
+	if !reflect.DeepEqual(s.Events, expected) {
+		t.Fatalf("Wanted %v, but got %v", common.Prettify(expected), common.Prettify(s.Events))
+	}
+	if eliminated := s.Events.Eliminated(); !reflect.DeepEqual(eliminated, PlayerIds{"p2"}) {
+		t.Fatalf("Wanted p2 to be eliminated, but got %v", eliminated)
+	}
+	rules := DefaultRules()
+	rules.FogOfWar = true
+	if events := s.VisibleTo("p3", rules).Events; !reflect.DeepEqual(events, Events{expected[2]}) {
+		t.Fatalf("Wanted p3 to see only the growth at e, but got %v", common.Prettify(events))
+	}
+	if events := s.VisibleTo("p2", rules).Events; !reflect.DeepEqual(events, Events{expected[4]}) {
+		t.Fatalf("Wanted p2 to see only its own elimination, but got %v", common.Prettify(events))
+	}
+}
+
+func TestResign(t *testing.T) {
+	s := testState()
+	s.Nodes[a].Units["p1"] = 10
+	s.Nodes[e].Units["p2"] = 10
+	s.Nodes[g].Units["p3"] = 10
+	s.Nodes[d].Edges[e].Units[1]["p2"] = 5
+	s.Next(nil, nil)
+	winner := s.Next(nil, map[PlayerId]Orders{
+		"p2": Orders{Order{Src: e, Dst: c, Units: 5}, Order{Resign: true}},
+	})
+	if winner != nil {
+		t.Fatalf("Wanted no winner, but got %v", *winner)
+	}
+	if verdicts := s.Verdicts["p2"]; verdicts[0].Status != VerdictRejected || verdicts[1].Status != VerdictAccepted {
+		t.Fatalf("Wanted the resign order to be accepted and the other one rejected, but got %+v", verdicts)
+	}
+	if units := s.Units()["p2"]; units != 0 {
+		t.Fatalf("Wanted p2 to have no units left, but got %v", units)
+	}
+	if !reflect.DeepEqual(s.Eliminated, map[PlayerId]int{"p2": 2}) {
+		t.Fatalf("Wanted p2 to be eliminated in turn 2, but got %v", s.Eliminated)
+	}
+	if event := s.Events[len(s.Events)-1].PlayerEliminated; event == nil || event.Player != "p2" || !event.Resigned {
+		t.Fatalf("Wanted the last event to be p2 resigning, but got %+v", s.Events)
+	}
+	if winner = s.Next(nil, map[PlayerId]Orders{"p3": Orders{Order{Resign: true}}}); winner == nil || *winner != "p1" {
+		t.Fatalf("Wanted p1 to win when p3 resigned, but got %v", winner)
+	}
+	placements := s.Ranking([]PlayerId{"p1", "p2", "p3"}, s.Eliminated)
+	for index, playerId := range []PlayerId{"p1", "p3", "p2"} {
+		if placements[index].Player != playerId || placements[index].Rank != index+1 {
+			t.Fatalf("Wanted p1, p3 and p2 to be ranked in that order, but got %+v", placements)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package state
+
+import (
+	"fmt"
+)
+
+type VerdictStatus string
+
+const (
+	// VerdictAccepted means the order was executed as given.
+	VerdictAccepted VerdictStatus = "Accepted"
+	// VerdictClamped means the order was executed, but with fewer units than ordered.
+	VerdictClamped VerdictStatus = "Clamped"
+	// VerdictRejected means the order was not executed at all.
+	VerdictRejected VerdictStatus = "Rejected"
+)
+
+/*
+Verdict describes what happened to a single order.
+*/
+type Verdict struct {
+	// Order is the order as given by the AI.
+	Order Order
+	// Status is whether the order was accepted, clamped or rejected.
+	Status VerdictStatus
+	// Units is the number of units actually moved.
+	Units int
+	// Reason explains why the order was clamped or rejected.
+	Reason string `json:",omitempty"`
+}
+
+/*
+Verdicts contains one verdict per order, in the same order as the orders were given.
+*/
+type Verdicts []Verdict
+
+/*
+Rejected returns the verdicts that weren't accepted as given.
+*/
+func (self Verdicts) Rejected() (result Verdicts) {
+	for _, verdict := range self {
+		if verdict.Status != VerdictAccepted {
+			result = append(result, verdict)
+		}
+	}
+	return
+}
+
+/*
+ValidateOrders returns a verdict for each of the orders if given by playerId in self.
+
+Orders are validated in sequence, so an order moving units from a node that earlier orders already emptied will be clamped or rejected.
+Resign orders are always accepted, and make all other orders rejected.
+Orders are rejected if their source node or edge doesn't exist, if they move less than one unit, if they duplicate an earlier order along the same edge, or if the player has no units left at the source node.
+*/
+func (self *State) ValidateOrders(playerId PlayerId, orders Orders) (result Verdicts) {
+	result = make(Verdicts, 0, len(orders))
+	available := map[NodeId]int{}
+	seen := map[Order]bool{}
+	resigns := orders.Resigns()
+	for _, order := range orders {
+		verdict := Verdict{
+			Order:  order,
+			Status: VerdictRejected,
+		}
+		key := Order{
+			Src: order.Src,
+			Dst: order.Dst,
+		}
+		if order.Resign {
+			verdict.Status = VerdictAccepted
+		} else if resigns {
+			verdict.Reason = "The player resigned"
+		} else if src, found := self.Nodes[order.Src]; !found {
+			verdict.Reason = fmt.Sprintf("No node %v", order.Src)
+		} else if _, found := src.Edges[order.Dst]; !found {
+			verdict.Reason = fmt.Sprintf("No edge from %v to %v", order.Src, order.Dst)
+		} else if order.Units < 1 {
+			verdict.Reason = fmt.Sprintf("Units must be positive, not %v", order.Units)
+		} else if seen[key] {
+			verdict.Reason = fmt.Sprintf("Duplicate order from %v to %v", order.Src, order.Dst)
+		} else {
+			seen[key] = true
+			left, found := available[order.Src]
+			if !found {
+				left = src.Units[playerId]
+			}
+			if left < 1 {
+				verdict.Reason = fmt.Sprintf("No units left at %v", order.Src)
+			} else if left < order.Units {
+				verdict.Status = VerdictClamped
+				verdict.Units = left
+				verdict.Reason = fmt.Sprintf("Only %v units left at %v", left, order.Src)
+			} else {
+				verdict.Status = VerdictAccepted
+				verdict.Units = order.Units
+			}
+			available[order.Src] = left - verdict.Units
+		}
+		result = append(result, verdict)
+	}
+	return
+}
@@ -0,0 +1,100 @@
+package state
+
+/*
+VisibleTo returns the part of self that playerId is allowed to see under rules.
+
+Without fog of war, self is returned as is. With fog of war, the result is a copy of self containing only the nodes where playerId has units, the nodes bordering them, the edges touching the nodes where playerId has units, and the edges (with their end nodes) where playerId has units in transit.
+The Seed is cleared, and Starts, Eliminated, Orders, Verdicts and PlayerEliminated events are only kept for playerId. Changes and other Events are only kept for visible nodes.
+*/
+func (self *State) VisibleTo(playerId PlayerId, rules Rules) (result *State) {
+	if !rules.FogOfWar {
+		return self
+	}
+	// find the nodes playerId occupies, and the edges playerId travels along
+	occupied := map[NodeId]bool{}
+	travelled := map[NodeId]map[NodeId]bool{}
+	for _, node := range self.Nodes {
+		if node.Units[playerId] > 0 {
+			occupied[node.Id] = true
+		}
+		for _, edge := range node.Edges {
+			for _, spot := range edge.Units {
+				if spot[playerId] > 0 {
+					if travelled[edge.Src] == nil {
+						travelled[edge.Src] = map[NodeId]bool{}
+					}
+					travelled[edge.Src][edge.Dst] = true
+				}
+			}
+		}
+	}
+	// an edge is visible if it touches an occupied node, or carries our units
+	edgeVisible := func(edge Edge) bool {
+		return occupied[edge.Src] || occupied[edge.Dst] || travelled[edge.Src][edge.Dst]
+	}
+	// a node is visible if it is the end of a visible edge, or occupied (even if it has no edges)
+	visible := map[NodeId]bool{}
+	for nodeId, _ := range occupied {
+		visible[nodeId] = true
+	}
+	for _, node := range self.Nodes {
+		for _, edge := range node.Edges {
+			if edgeVisible(edge) {
+				visible[edge.Src] = true
+				visible[edge.Dst] = true
+			}
+		}
+	}
+	result = self.Clone()
+	// the seed would let playerId regenerate the whole map, and the starts of the others would give away where they are
+	result.Seed = 0
+	for otherId, _ := range result.Starts {
+		if otherId != playerId {
+			delete(result.Starts, otherId)
+		}
+	}
+	for nodeId, node := range result.Nodes {
+		if !visible[nodeId] {
+			delete(result.Nodes, nodeId)
+			continue
+		}
+		for dst, edge := range node.Edges {
+			if !edgeVisible(edge) {
+				delete(node.Edges, dst)
+			}
+		}
+	}
+	for nodeId, _ := range result.Changes {
+		if !visible[nodeId] {
+			delete(result.Changes, nodeId)
+		}
+	}
+	events := Events{}
+	for _, event := range result.Events {
+		if eliminated := event.PlayerEliminated; eliminated != nil {
+			// eliminations elsewhere would tell playerId about parts of the map it can't see
+			if eliminated.Player == playerId {
+				events = append(events, event)
+			}
+		} else if nodeId := event.Node(); nodeId == "" || visible[nodeId] {
+			events = append(events, event)
+		}
+	}
+	result.Events = events
+	for otherId, _ := range result.Eliminated {
+		if otherId != playerId {
+			delete(result.Eliminated, otherId)
+		}
+	}
+	for otherId, _ := range result.Orders {
+		if otherId != playerId {
+			delete(result.Orders, otherId)
+		}
+	}
+	for otherId, _ := range result.Verdicts {
+		if otherId != playerId {
+			delete(result.Verdicts, otherId)
+		}
+	}
+	return
+}